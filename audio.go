@@ -0,0 +1,150 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WhisperModel the default speech to text model
+const WhisperModel = "whisper-1"
+
+// AudioResponseFormat the format of the transcript output
+type AudioResponseFormat string
+
+const (
+	AudioResponseFormatJSON        AudioResponseFormat = "json"
+	AudioResponseFormatText        AudioResponseFormat = "text"
+	AudioResponseFormatSRT         AudioResponseFormat = "srt"
+	AudioResponseFormatVTT         AudioResponseFormat = "vtt"
+	AudioResponseFormatVerboseJSON AudioResponseFormat = "verbose_json"
+)
+
+// AudioRequest is a request for the audio transcriptions and translations API
+type AudioRequest struct {
+	// The audio content, in one of these formats: flac, mp3, mp4, mpeg, mpga, m4a, ogg, wav, or webm.
+	File io.Reader `json:"-"`
+	// The file name of the audio, the extension tells the API the format, e.g. "speech.mp3".
+	FileName string `json:"file_name"`
+	// ID of the model to use, e.g. WhisperModel.
+	Model string `json:"model"`
+	// The language of the input audio in ISO-639-1 format, e.g. "zh". Only for transcriptions.
+	Language string `json:"language,omitempty"`
+	// An optional text to guide the model's style or continue a previous audio segment.
+	Prompt string `json:"prompt,omitempty"`
+	// The format of the transcript output, defaults to json.
+	ResponseFormat AudioResponseFormat `json:"response_format,omitempty"`
+	// The sampling temperature, between 0 and 1.
+	Temperature *float32 `json:"temperature,omitempty"`
+}
+
+func (r AudioRequest) writeMultipart(w *multipart.Writer) error {
+	fw, err := w.CreateFormFile("file", r.FileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, r.File); err != nil {
+		return err
+	}
+	fields := [][2]string{
+		{"model", r.Model},
+		{"language", r.Language},
+		{"prompt", r.Prompt},
+		{"response_format", string(r.ResponseFormat)},
+	}
+	if r.Temperature != nil {
+		fields = append(fields, [2]string{"temperature", strconv.FormatFloat(float64(*r.Temperature), 'f', -1, 32)})
+	}
+	for _, field := range fields {
+		if len(field[1]) == 0 {
+			continue
+		}
+		if err := w.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AudioResponse is the response from a transcriptions or translations request.
+// For the text, srt and vtt formats only Text is set, holding the raw output.
+type AudioResponse struct {
+	// Task "transcribe" or "translate", verbose_json only
+	Task string `json:"task,omitempty"`
+	// Language the language of the input audio, verbose_json only
+	Language string `json:"language,omitempty"`
+	// Duration the duration of the input audio in seconds, verbose_json only
+	Duration float64 `json:"duration,omitempty"`
+	// Text the transcript
+	Text string `json:"text"`
+	// Segments the segments of the transcript with timestamps, verbose_json only
+	Segments []AudioSegment `json:"segments,omitempty"`
+}
+
+// AudioSegment a segment of the transcript
+type AudioSegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+// decodeResponse json 及 verbose_json 格式解析为结构体，其他格式原样放在 Text 中
+func (r *AudioResponse) decodeResponse(rsp *http.Response) error {
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(rsp.Header.Get("Content-Type")); strings.HasSuffix(mediaType, "json") {
+		if err := json.Unmarshal(body, r); err != nil {
+			return errors.Errorf("invalid json response: %v", err)
+		}
+		return nil
+	}
+	r.Text = string(body)
+	return nil
+}
+
+// CreateTranscription transcribes audio into the input language.
+//
+// See: https://platform.openai.com/docs/api-reference/audio/createTranscription
+func (c *client) CreateTranscription(ctx context.Context, request AudioRequest) (output *AudioResponse, err error) {
+	return c.audio(ctx, genAITranscription, OperationTranscriptions, "/audio/transcriptions", request)
+}
+
+// CreateTranslation translates audio into English.
+//
+// See: https://platform.openai.com/docs/api-reference/audio/createTranslation
+func (c *client) CreateTranslation(ctx context.Context, request AudioRequest) (output *AudioResponse, err error) {
+	// 翻译接口不支持 language
+	request.Language = ""
+	return c.audio(ctx, genAITranslation, OperationTranslations, "/audio/translations", request)
+}
+
+func (c *client) audio(ctx context.Context, genAIOperation, operation, path string, request AudioRequest) (output *AudioResponse, err error) {
+	ctx, span := c.telemetry.start(ctx, genAIOperation, request.Model, request)
+	defer func() { span.end(output, err) }()
+	req, err := c.newRequest(ctx, operation, "POST", path, request)
+	if err != nil {
+		return nil, err
+	}
+
+	output = new(AudioResponse)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
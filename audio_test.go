@@ -0,0 +1,81 @@
+package gpt3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAudio(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		if header.Filename != "speech.mp3" || string(data) != "audio bytes" {
+			t.Errorf("file = %q, %q", header.Filename, data)
+		}
+		if r.FormValue("model") != WhisperModel {
+			t.Errorf("model = %q", r.FormValue("model"))
+		}
+		if r.URL.Path == "/audio/translations" {
+			if r.FormValue("language") != "" {
+				t.Errorf("translation language = %q", r.FormValue("language"))
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, "1\n00:00:00,000 --> 00:00:01,000\nhello\n")
+			return
+		}
+		if r.FormValue("language") != "zh" || r.FormValue("response_format") != "verbose_json" {
+			t.Errorf("form = %v", r.MultipartForm.Value)
+		}
+		if attempts == 1 {
+			// 重试时重新发送相同的请求体
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"task":"transcribe","language":"chinese","duration":1.5,"text":"你好","segments":[{"id":0,"start":0,"end":1.5,"text":"你好"}]}`)
+	}))
+	defer server.Close()
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithMaxRetry(2))
+
+	resp, err := c.Transcribe(context.Background(), AudioRequest{
+		File:           strings.NewReader("audio bytes"),
+		FileName:       "speech.mp3",
+		Language:       "zh",
+		ResponseFormat: AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || resp.Text != "你好" || resp.Duration != 1.5 || len(resp.Segments) != 1 || resp.Segments[0].End != 1.5 {
+		t.Errorf("Transcribe = %+v after %v attempts", resp, attempts)
+	}
+
+	resp, err = c.Translate(context.Background(), AudioRequest{
+		File:           strings.NewReader("audio bytes"),
+		FileName:       "speech.mp3",
+		Language:       "zh",
+		ResponseFormat: AudioResponseFormatSRT,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Text, "00:00:00,000 --> 00:00:01,000") {
+		t.Errorf("Translate = %q", resp.Text)
+	}
+}
@@ -0,0 +1,137 @@
+package gpt3
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type tenantKey struct{}
+
+// ContextWithTenant 返回带有租户的 ctx，Budget 按租户统计及限制花费
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext 返回 ctx 中的租户；没有时为空字符串，所有没有租户的请求共用一份预算
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Budget 按租户限制每天及每月的花费(美元)。
+// 价格表中没有的模型默认拒绝请求，返回 ErrUnpricedModel，见 FallbackPrice。
+// 发送请求前按提示的 token 数加上最多生成的 token 数估计花费，已花费加上估计超出上限时拒绝请求，返回 ErrBudgetExceeded；
+// 请求完成后按实际用量计入花费；流式请求未通过 WithStreamUsage 要求返回用量时，流结束时按提示及已生成内容的 token 数估计计入。
+// 并发的请求可能使花费略微超出上限。
+// 花费只记录在内存中，进程重启后清零。Budget 可以并发使用。
+type Budget struct {
+	// Daily 每个租户每天的花费上限，0 表示不限制
+	Daily float64
+	// Monthly 每个租户每月的花费上限，0 表示不限制
+	Monthly float64
+	// Location 按此时区划分天及月，nil 表示 UTC
+	Location *time.Location
+	// FallbackPrice 价格表中没有的模型(如微调模型、Azure 的部署名)使用的价格；
+	// nil 表示拒绝这些模型的请求，返回 ErrUnpricedModel，而不是当作免费放行
+	FallbackPrice *Price
+
+	mu    sync.Mutex
+	spend map[string]*tenantSpend
+	now   func() time.Time
+}
+
+type tenantSpend struct {
+	day     string
+	daily   float64
+	month   string
+	monthly float64
+}
+
+// NewBudget 创建 Budget
+func NewBudget(daily, monthly float64) *Budget {
+	return &Budget{
+		Daily:   daily,
+		Monthly: monthly,
+	}
+}
+
+// Spent 返回租户今天及本月已经花费的金额
+func (b *Budget) Spent(tenant string) (daily, monthly float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.tenant(tenant)
+	return s.daily, s.monthly
+}
+
+// Charge 计入租户的花费，例如不经过本客户端的请求
+func (b *Budget) Charge(tenant string, cost float64) {
+	if b == nil || cost <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.tenant(tenant)
+	s.daily += cost
+	s.monthly += cost
+}
+
+// price 返回模型的价格；价格表中没有时使用 FallbackPrice，都没有时返回 ErrUnpricedModel
+func (b *Budget) price(pricing Pricing, model string) (Price, error) {
+	if price, ok := pricing.Price(model); ok {
+		return price, nil
+	}
+	if b.FallbackPrice != nil {
+		return *b.FallbackPrice, nil
+	}
+	return Price{}, errors.Wrapf(ErrUnpricedModel, "模型%q不在价格表中，无法检查预算；请通过 WithPricing 补充价格或设置 Budget.FallbackPrice", model)
+}
+
+// check 已花费加上 estimate 超出上限时返回 ErrBudgetExceeded
+func (b *Budget) check(tenant string, estimate float64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.tenant(tenant)
+	if b.Daily > 0 && s.daily+estimate > b.Daily {
+		return errors.Wrapf(ErrBudgetExceeded, "租户%q今日已花费%.4f美元, 本次预计%.4f, 上限%.4f", tenant, s.daily, estimate, b.Daily)
+	}
+	if b.Monthly > 0 && s.monthly+estimate > b.Monthly {
+		return errors.Wrapf(ErrBudgetExceeded, "租户%q本月已花费%.4f美元, 本次预计%.4f, 上限%.4f", tenant, s.monthly, estimate, b.Monthly)
+	}
+	return nil
+}
+
+// tenant 返回租户的花费，跨天或跨月时清零对应的花费
+func (b *Budget) tenant(tenant string) *tenantSpend {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	loc := b.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t := now().In(loc)
+	day, month := t.Format("2006-01-02"), t.Format("2006-01")
+
+	if b.spend == nil {
+		b.spend = map[string]*tenantSpend{}
+	}
+	s, ok := b.spend[tenant]
+	if !ok {
+		s = &tenantSpend{day: day, month: month}
+		b.spend[tenant] = s
+	}
+	if s.day != day {
+		s.day, s.daily = day, 0
+	}
+	if s.month != month {
+		s.month, s.monthly = month, 0
+	}
+	return s
+}
@@ -0,0 +1,149 @@
+package gpt3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPricing(t *testing.T) {
+	tests := []struct {
+		model string
+		want  float64
+		found bool
+	}{
+		{"gpt-4", 30 + 60, true},
+		{"gpt-4-0613", 30 + 60, true},
+		{"gpt-4-turbo-2024-04-09", 10 + 30, true},
+		{"gpt-4o-2024-08-06", 0.5*2.5 + 0.5*1.25 + 10, true},
+		{"gpt-4o-mini", 0.5*0.15 + 0.5*0.075 + 0.6, true},
+		{"unknown", 0, false},
+	}
+	// 一百万提示 token 中一半命中缓存，一百万生成 token；没有缓存价格的模型按原价计算
+	usage := Usage{PromptTokens: 1e6, CompletionTokens: 1e6, PromptTokensDetails: PromptTokensDetails{CachedTokens: 5e5}}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, found := DefaultPricing.Cost(tt.model, usage)
+			if found != tt.found || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Cost() = %v, %v, want %v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":1000,"completion_tokens":1000,"total_tokens":2000}}`))
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	budget := NewBudget(0.19, 0.28)
+	budget.now = func() time.Time { return now }
+	// gpt-4: 每次请求实际花费 0.09 美元；发送前按提示的 token 数加上 maxtokens=256 估计约 0.016 美元
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(Gpt4Engine), WithBudget(budget))
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+	alice := ContextWithTenant(context.Background(), "alice")
+
+	ctx, usage := TrackUsage(alice)
+	for i := 0; i < 2; i++ {
+		if _, err := c.DoOnce(ctx, say); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if math.Abs(usage.Cost()-0.18) > 1e-9 {
+		t.Errorf("cost = %v", usage.Cost())
+	}
+	if _, err := c.DoOnce(alice, say); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("daily limit: err = %v", err)
+	}
+	if _, err := c.DoOnce(ContextWithTenant(context.Background(), "bob"), say); err != nil {
+		t.Errorf("other tenant: err = %v", err)
+	}
+
+	// 第二天日预算重置
+	now = now.AddDate(0, 0, 1)
+	if _, err := c.DoOnce(alice, say); err != nil {
+		t.Errorf("next day: err = %v", err)
+	}
+	// 月预算仍然生效
+	now = now.AddDate(0, 0, 1)
+	if _, err := c.DoOnce(alice, say); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("monthly limit: err = %v", err)
+	}
+	// 下个月重置
+	now = now.AddDate(0, 1, 0)
+	if _, err := c.DoOnce(alice, say); err != nil {
+		t.Errorf("next month: err = %v", err)
+	}
+	if daily, monthly := budget.Spent("alice"); math.Abs(daily-0.09) > 1e-9 || math.Abs(monthly-0.09) > 1e-9 {
+		t.Errorf("spent = %v, %v", daily, monthly)
+	}
+}
+
+func TestBudgetUnpricedModel(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"choices":[{"text":"ok"}],"usage":{"prompt_tokens":1000,"completion_tokens":1000,"total_tokens":2000}}`))
+	}))
+	defer server.Close()
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+	const model = "ft:davinci-002:acme::abc123"
+
+	// 没有价格的模型不能当作免费放行
+	budget := NewBudget(1, 0)
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(model), WithBudget(budget))
+	if _, err := c.DoOnce(context.Background(), say); !errors.Is(err, ErrUnpricedModel) {
+		t.Errorf("err = %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("%v requests sent", requests)
+	}
+
+	// 设置 FallbackPrice 后按其计入预算
+	budget.FallbackPrice = &Price{Input: 100, Output: 100}
+	if _, err := c.DoOnce(context.Background(), say); err != nil {
+		t.Fatal(err)
+	}
+	if daily, _ := budget.Spent(""); math.Abs(daily-0.2) > 1e-9 {
+		t.Errorf("spent = %v", daily)
+	}
+}
+
+func TestBudgetStream(t *testing.T) {
+	text := strings.Repeat("hello ", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":%q}}]}\n\n", text)
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	// 默认不要求返回用量，流结束时按提示及已生成内容估计花费：
+	// gpt-4 发送前按 maxtokens=256 估计约 0.016 美元，生成约1000个 token 计入约 0.06 美元
+	budget := NewBudget(0.02, 0)
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(Gpt4Engine), WithBudget(budget))
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+	ctx, usage := TrackUsage(context.Background())
+	if err := c.DoStream(ctx, say, func(CompletionResponseInterface) {}); err != nil {
+		t.Fatal(err)
+	}
+	want := Price{Input: 30, Output: 60}.Cost(Usage{CompletionTokens: countTokens(text)})
+	if daily, _ := budget.Spent(""); daily < want {
+		t.Errorf("spent = %v, want at least %v", daily, want)
+	}
+	// 估计的用量只计入预算，不计入 UsageRecorder
+	if usage.Cost() != 0 {
+		t.Errorf("recorded cost = %v", usage.Cost())
+	}
+	if err := c.DoStream(ctx, say, func(CompletionResponseInterface) {}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -2,13 +2,28 @@ package gpt3
 
 import (
 	"context"
-	"net/http"
-	"time"
+)
+
+// Chat message roles
+const (
+	ChatMessageRoleSystem    = "system"
+	ChatMessageRoleUser      = "user"
+	ChatMessageRoleAssistant = "assistant"
+	ChatMessageRoleTool      = "tool"
+	ChatMessageRoleFunction  = "function"
 )
 
 type ChatCompletionMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// The name of the author of this message, or the name of the function for "function" role messages.
+	Name string `json:"name,omitempty"`
+	// The tool calls generated by the model, set on "assistant" messages.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Deprecated: use ToolCalls. The function call generated by the model, set on "assistant" messages.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+	// The id of the tool call this message is responding to, set on "tool" messages.
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 // ChatCompletionRequest is a request for the chat/completions API
@@ -32,20 +47,34 @@ type ChatCompletionRequest struct {
 	// Modify the likelihood of specified tokens appearing in the completion.
 	// Accepts a json object that maps tokens (specified by their token ID in the tokenizer) to an associated bias value from -100 to 100. Mathematically, the bias is added to the logits generated by the model prior to sampling. The exact effect will vary per model, but values between -1 and 1 should decrease or increase likelihood of selection; values like -100 or 100 should result in a ban or exclusive selection of the relevant token.
 	LogitBias map[string]string `json:"logit_bias,omitempty"`
+	// A list of tools the model may call. Currently, only functions are supported as a tool.
+	Tools []Tool `json:"tools,omitempty"`
+	// Controls which (if any) tool is called by the model. Either one of the ToolChoiceXXX strings
+	// or a ToolChoice value naming a specific function.
+	ToolChoice interface{} `json:"tool_choice,omitempty"`
+	// Deprecated: use Tools. A list of functions the model may generate JSON inputs for.
+	Functions []FunctionDefinition `json:"functions,omitempty"`
+	// Deprecated: use ToolChoice. Either "none", "auto" or a FunctionCall value naming a specific function.
+	FunctionCall interface{} `json:"function_call,omitempty"`
 
 	// Whether to stream back results or not. Don't set this value in the request yourself
 	// as it will be overriden depending on if you use CompletionStream or Completion methods.
 	Stream bool `json:"stream,omitempty"`
+	// Options for streaming response. Only set this when you set stream: true.
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 }
 
 type ChatCompletionResponseChoiceMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Deprecated: use ToolCalls.
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 }
 
 // CompletionResponseChoice is one of the choices returned in the response to the Completions API
 type ChatCompletionResponseChoice struct {
-	// Index        int                                 `json:"index"`
+	Index        int                                 `json:"index"`
 	Message      ChatCompletionResponseChoiceMessage `json:"message"`
 	FinishReason string                              `json:"finish_reason"`
 }
@@ -68,10 +97,10 @@ type ChatCompletionResponseChoice struct {
 
 // CompletionResponse is the full response from a request to the completions API
 type ChatCompletionResponse struct {
-	// ID      string                         `json:"id"`
-	// Object  string                         `json:"object"`
-	// Created int                            `json:"created"`
-	// Model   string                         `json:"model"`
+	ID      string                         `json:"id"`
+	Object  string                         `json:"object"`
+	Created int64                          `json:"created"`
+	Model   string                         `json:"model"`
 	Choices []ChatCompletionResponseChoice `json:"choices"`
 	Usage   ChatCompletionResponseUsage    `json:"usage"`
 }
@@ -97,6 +126,24 @@ func (cr *ChatCompletionResponse) Role() string {
 	return "assistant"
 }
 
+func (cr *ChatCompletionResponse) AllChoices() []Choice {
+	if cr == nil {
+		return nil
+	}
+	choices := make([]Choice, 0, len(cr.Choices))
+	for _, c := range cr.Choices {
+		choices = append(choices, Choice{
+			Index:        c.Index,
+			Role:         c.Message.Role,
+			Text:         c.Message.Content,
+			FinishReason: c.FinishReason,
+			ToolCalls:    c.Message.ToolCalls,
+			FunctionCall: c.Message.FunctionCall,
+		})
+	}
+	return choices
+}
+
 func (cr *ChatCompletionResponse) TotalTokens() int {
 	if cr != nil {
 		return cr.Usage.TotalTokens
@@ -104,6 +151,13 @@ func (cr *ChatCompletionResponse) TotalTokens() int {
 	return 0
 }
 
+func (cr *ChatCompletionResponse) TokenUsage() Usage {
+	if cr != nil {
+		return cr.Usage
+	}
+	return Usage{}
+}
+
 func (cr *ChatCompletionResponse) Reset() {
 	if cr != nil {
 		*cr = ChatCompletionResponse{}
@@ -113,42 +167,84 @@ func (cr *ChatCompletionResponse) Reset() {
 
 // ChatStreamCompletionResponseChoice is one of the choices returned in the response to the Completions API
 type ChatStreamCompletionResponseChoice struct {
-	// Index        int                                 `json:"index"`
+	Index        int                                 `json:"index"`
 	Message      ChatCompletionResponseChoiceMessage `json:"delta"`
 	FinishReason string                              `json:"finish_reason"`
+
+	// ToolCalls holds the tool calls of this choice stitched together from all previous deltas.
+	// It is only set by StreamOnData on the chunk that carries the finish reason.
+	ToolCalls []ToolCall `json:"-"`
+	// Deprecated: use ToolCalls. FunctionCall is stitched together the same way as ToolCalls.
+	FunctionCall *FunctionCall `json:"-"`
 }
 
 // ChatStreamCompletionResponse is the full response from a request to the completions API
 type ChatStreamCompletionResponse struct {
-	// ID      string                         `json:"id"`
-	// Object  string                         `json:"object"`
-	// Created int                            `json:"created"`
-	// Model   string                         `json:"model"`
+	ID      string                               `json:"id"`
+	Object  string                               `json:"object"`
+	Created int64                                `json:"created"`
+	Model   string                               `json:"model"`
 	Choices []ChatStreamCompletionResponseChoice `json:"choices"`
 	Usage   ChatCompletionResponseUsage          `json:"usage"`
 }
 
+// first 返回 index 为0的 choice；n>1 时每个数据块只携带其中一个 choice 的增量
+func (cr *ChatStreamCompletionResponse) first() *ChatStreamCompletionResponseChoice {
+	if cr == nil {
+		return nil
+	}
+	for i := range cr.Choices {
+		if cr.Choices[i].Index == 0 {
+			return &cr.Choices[i]
+		}
+	}
+	return nil
+}
+
 func (cr *ChatStreamCompletionResponse) CanContinue() bool {
-	if cr != nil && len(cr.Choices) > 0 {
-		return cr.Choices[0].FinishReason == "length"
+	if c := cr.first(); c != nil {
+		return c.FinishReason == "length"
 	}
 	return false
 }
 
 func (cr *ChatStreamCompletionResponse) Text() string {
-	if cr != nil && len(cr.Choices) > 0 {
-		return cr.Choices[0].Message.Content
+	if c := cr.first(); c != nil {
+		return c.Message.Content
 	}
 	return ""
 }
 
 func (cr *ChatStreamCompletionResponse) Role() string {
-	if cr != nil && len(cr.Choices) > 0 {
-		return cr.Choices[0].Message.Role
+	if c := cr.first(); c != nil && len(c.Message.Role) > 0 {
+		return c.Message.Role
 	}
 	return "assistant"
 }
 
+// AllChoices 返回数据块中每个 choice 的增量；ToolCalls 及 FunctionCall 只在携带结束原因的数据块中设置，为拼接后的完整调用
+func (cr *ChatStreamCompletionResponse) AllChoices() []Choice {
+	if cr == nil {
+		return nil
+	}
+	choices := make([]Choice, 0, len(cr.Choices))
+	for _, c := range cr.Choices {
+		role := c.Message.Role
+		if len(role) == 0 {
+			role = ChatMessageRoleAssistant
+		}
+		choices = append(choices, Choice{
+			Index:        c.Index,
+			Role:         role,
+			Text:         c.Message.Content,
+			FinishReason: c.FinishReason,
+			ToolCalls:    c.ToolCalls,
+			FunctionCall: c.FunctionCall,
+		})
+	}
+	return choices
+}
+
 func (cr *ChatStreamCompletionResponse) TotalTokens() int {
 	if cr != nil {
 		return cr.Usage.TotalTokens
@@ -156,6 +252,14 @@ func (cr *ChatStreamCompletionResponse) TotalTokens() int {
 	return 0
 }
 
+// TokenUsage 开启 StreamOptions.IncludeUsage 时，最后一个数据块(choices 为空)携带整个请求的用量，其他数据块为零值
+func (cr *ChatStreamCompletionResponse) TokenUsage() Usage {
+	if cr != nil {
+		return cr.Usage
+	}
+	return Usage{}
+}
+
 func (cr *ChatStreamCompletionResponse) Reset() {
 	if cr != nil {
 		*cr = ChatStreamCompletionResponse{}
@@ -164,34 +268,24 @@ func (cr *ChatStreamCompletionResponse) Reset() {
 }
 
 // CompletionResponseUsage is the object that returns how many tokens the completion's request used
-type ChatCompletionResponseUsage struct {
-	// PromptTokens     int `json:"prompt_tokens"`
-	// CompletionTokens int `json:"completion_tokens"`
-	TotalTokens int `json:"total_tokens"`
-}
+type ChatCompletionResponseUsage = Usage
 
-func (c *client) ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+func (c *client) ChatCompletion(ctx context.Context, request ChatCompletionRequest) (output *ChatCompletionResponse, err error) {
 	request.Stream = false
-	req, err := c.newRequest(ctx, "POST", "/chat/completions", request)
-	if err != nil {
+	ctx, span := c.telemetry.start(ctx, genAIChat, string(request.Model), request)
+	defer func() { span.end(output, err) }()
+	if err := c.admit(ctx, string(request.Model), estimateChatTokens(request)); err != nil {
 		return nil, err
 	}
-
-	var resp *http.Response
-	err = retry(func() error {
-		resp, err = c.performRequest(req)
-		return err
-	}, c.gpt3.maxretry, time.Second/2)
-
+	req, err := c.newRequest(ctx, OperationChatCompletions, "POST", "/chat/completions", request)
 	if err != nil {
 		return nil, err
 	}
 
-	output := new(ChatCompletionResponse)
-	if err = getResponseObject(resp, output); err != nil {
+	output = new(ChatCompletionResponse)
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
-
 	return output, nil
 }
 
@@ -203,11 +297,42 @@ func (c *client) ChatCompletionStreamWithEngine(
 	ctx context.Context,
 	request ChatCompletionRequest,
 	onData func(CompletionResponseInterface),
-) error {
+) (err error) {
 	request.Stream = true
-	req, err := c.newRequest(ctx, "POST", "/chat/completions", request)
+	ctx, span := c.telemetry.start(ctx, genAIChat, string(request.Model), request)
+	defer func() {
+		if err != nil {
+			span.end(nil, err)
+		}
+	}()
+	if err := c.admit(ctx, string(request.Model), estimateChatTokens(request)); err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, OperationChatCompletions, "POST", "/chat/completions", request)
 	if err != nil {
 		return err
 	}
-	return c.sendAndOnData(req, new(ChatStreamCompletionResponse), onData)
+	return c.sendAndOnData(req, span, estimateChatTokens(request), new(ChatStreamCompletionResponse), onData)
+}
+
+// OpenChatCompletionStream creates a chat completion and returns a Stream of *ChatStreamCompletionResponse chunks.
+// The caller must Close the stream.
+func (c *client) OpenChatCompletionStream(ctx context.Context, request ChatCompletionRequest) (stream *Stream, err error) {
+	request.Stream = true
+	ctx, span := c.telemetry.start(ctx, genAIChat, string(request.Model), request)
+	defer func() {
+		if err != nil {
+			span.end(nil, err)
+		}
+	}()
+	if err := c.admit(ctx, string(request.Model), estimateChatTokens(request)); err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, OperationChatCompletions, "POST", "/chat/completions", request)
+	if err != nil {
+		return nil, err
+	}
+	return c.openStream(req, span, estimateChatTokens(request), func() CompletionResponseInterface {
+		return new(ChatStreamCompletionResponse)
+	})
 }
@@ -0,0 +1,138 @@
+package gpt3
+
+type ToolType string
+
+// Tool Types
+const (
+	ToolTypeFunction ToolType = "function"
+)
+
+// Tool choices, used as ChatCompletionRequest.ToolChoice
+const (
+	// The model will not call any tool and instead generates a message.
+	ToolChoiceNone = "none"
+	// The model can pick between generating a message or calling one or more tools.
+	ToolChoiceAuto = "auto"
+	// The model must call one or more tools.
+	ToolChoiceRequired = "required"
+)
+
+// FunctionDefinition describes a function the model may call.
+type FunctionDefinition struct {
+	// The name of the function to be called. Must be a-z, A-Z, 0-9, or contain underscores and dashes,
+	// with a maximum length of 64.
+	Name string `json:"name"`
+	// A description of what the function does, used by the model to choose when and how to call the function.
+	Description string `json:"description,omitempty"`
+	// The parameters the functions accepts, described as a JSON Schema object,
+	// e.g. a json.RawMessage or a map[string]interface{}.
+	Parameters interface{} `json:"parameters"`
+}
+
+// Tool is a tool the model may call.
+type Tool struct {
+	Type     ToolType           `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// ToolFunction names a function in a ToolChoice.
+type ToolFunction struct {
+	Name string `json:"name"`
+}
+
+// ToolChoice forces the model to call a specific function.
+type ToolChoice struct {
+	Type     ToolType     `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// FunctionCall is the name and arguments of a function that should be called, as generated by the model.
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// The arguments to call the function with, as generated by the model in JSON format.
+	// Note that the model does not always generate valid JSON, validate the arguments before calling the function.
+	Arguments string `json:"arguments"`
+}
+
+// ToolCall is a tool call generated by the model.
+type ToolCall struct {
+	// Index is only set in stream deltas, to identify which tool call a partial delta belongs to.
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     ToolType     `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// toolCallBuffer stitches the partial tool call deltas of a chat stream together,
+// per choice index.
+type toolCallBuffer struct {
+	calls     map[int][]ToolCall
+	functions map[int]*FunctionCall
+}
+
+// add merges the deltas of chunk into the buffer. For every choice that carries a finish reason,
+// the stitched tool calls are attached to the choice and dropped from the buffer.
+func (b *toolCallBuffer) add(chunk *ChatStreamCompletionResponse) {
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		for _, delta := range choice.Message.ToolCalls {
+			b.addToolCall(choice.Index, delta)
+		}
+		if delta := choice.Message.FunctionCall; delta != nil {
+			b.addFunctionCall(choice.Index, *delta)
+		}
+		if len(choice.FinishReason) == 0 {
+			continue
+		}
+		choice.ToolCalls = b.calls[choice.Index]
+		choice.FunctionCall = b.functions[choice.Index]
+		delete(b.calls, choice.Index)
+		delete(b.functions, choice.Index)
+	}
+}
+
+func (b *toolCallBuffer) addToolCall(choice int, delta ToolCall) {
+	if b.calls == nil {
+		b.calls = map[int][]ToolCall{}
+	}
+	calls := b.calls[choice]
+
+	idx := len(calls) - 1
+	if delta.Index != nil {
+		idx = *delta.Index
+	} else if len(delta.ID) > 0 || idx < 0 {
+		// 没有 index 的实现，以新的 id 作为新调用的开始
+		idx = len(calls)
+	}
+	for len(calls) <= idx {
+		calls = append(calls, ToolCall{})
+	}
+
+	call := &calls[idx]
+	if len(delta.ID) > 0 {
+		call.ID = delta.ID
+	}
+	if len(delta.Type) > 0 {
+		call.Type = delta.Type
+	}
+	if len(delta.Function.Name) > 0 {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	b.calls[choice] = calls
+}
+
+func (b *toolCallBuffer) addFunctionCall(choice int, delta FunctionCall) {
+	if b.functions == nil {
+		b.functions = map[int]*FunctionCall{}
+	}
+	call, ok := b.functions[choice]
+	if !ok {
+		call = new(FunctionCall)
+		b.functions[choice] = call
+	}
+	if len(delta.Name) > 0 {
+		call.Name = delta.Name
+	}
+	call.Arguments += delta.Arguments
+}
@@ -0,0 +1,116 @@
+package gpt3
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToolCallStitching(t *testing.T) {
+	weather := func(id, city string) ToolCall {
+		return ToolCall{ID: id, Type: ToolTypeFunction, Function: FunctionCall{Name: "weather", Arguments: `{"city":"` + city + `"}`}}
+	}
+	tests := []struct {
+		name string
+		body string
+		// calls 每个 choice 结束时拼接好的工具调用
+		calls map[int][]ToolCall
+		// functions 每个 choice 结束时拼接好的 function_call
+		functions map[int]*FunctionCall
+	}{
+		{
+			name: "parallel tool calls",
+			body: `data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":""}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"北京\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"上海\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+`,
+			calls: map[int][]ToolCall{0: {weather("call_1", "北京"), weather("call_2", "上海")}},
+		},
+		{
+			name: "without index",
+			body: `data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"function":{"arguments":"\"北京\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"id":"call_2","type":"function","function":{"name":"weather","arguments":"{\"city\":\"上海\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+`,
+			calls: map[int][]ToolCall{0: {weather("call_1", "北京"), weather("call_2", "上海")}},
+		},
+		{
+			name: "function call",
+			body: `data: {"choices":[{"index":0,"delta":{"role":"assistant","function_call":{"name":"weather","arguments":""}}}]}
+
+data: {"choices":[{"index":0,"delta":{"function_call":{"arguments":"{\"city\":"}}}]}
+
+data: {"choices":[{"index":0,"delta":{"function_call":{"arguments":"\"北京\"}"}}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}
+
+`,
+			functions: map[int]*FunctionCall{0: {Name: "weather", Arguments: `{"city":"北京"}`}},
+		},
+		{
+			name: "n choices",
+			body: `data: {"choices":[{"index":1,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_b","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}
+
+data: {"choices":[{"index":1,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"上海\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"北京\"}"}}]},"finish_reason":"tool_calls"}]}
+
+data: {"choices":[{"index":1,"delta":{},"finish_reason":"tool_calls"}]}
+
+`,
+			calls: map[int][]ToolCall{0: {weather("call_a", "北京")}, 1: {weather("call_b", "上海")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := map[int][]ToolCall{}
+			functions := map[int]*FunctionCall{}
+			err := StreamOnData(io.NopCloser(strings.NewReader(tt.body+"data: [DONE]\n\n")), new(ChatStreamCompletionResponse), func(chunk CompletionResponseInterface) {
+				for _, choice := range chunk.AllChoices() {
+					if len(choice.FinishReason) == 0 {
+						if len(choice.ToolCalls) > 0 || choice.FunctionCall != nil {
+							t.Errorf("choice %v: calls before finish", choice.Index)
+						}
+						continue
+					}
+					if len(choice.ToolCalls) > 0 {
+						calls[choice.Index] = choice.ToolCalls
+					}
+					if choice.FunctionCall != nil {
+						functions[choice.Index] = choice.FunctionCall
+					}
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.calls == nil {
+				tt.calls = map[int][]ToolCall{}
+			}
+			if tt.functions == nil {
+				tt.functions = map[int]*FunctionCall{}
+			}
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("tool calls = %+v, want %+v", calls, tt.calls)
+			}
+			if !reflect.DeepEqual(functions, tt.functions) {
+				t.Errorf("function calls = %+v, want %+v", functions, tt.functions)
+			}
+		})
+	}
+}
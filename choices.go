@@ -0,0 +1,19 @@
+package gpt3
+
+// Choice 一个候选回复，各响应类型的 choice 的通用形式；流式数据块中为该候选回复的增量
+type Choice struct {
+	// Index 候选回复的序号，从0开始
+	Index int
+	Role  string
+	Text  string
+	// FinishReason 结束原因，如 "stop"、"length"、"tool_calls"；流式数据块中只有最后一个有值
+	FinishReason string
+	ToolCalls    []ToolCall
+	// Deprecated: use ToolCalls. FunctionCall 为旧的 functions 接口生成的函数调用；流式数据块中与 ToolCalls 一样只在携带结束原因的数据块中设置
+	FunctionCall *FunctionCall
+}
+
+// CanContinue 回复是否因为长度限制被截断
+func (c Choice) CanContinue() bool {
+	return c.FinishReason == "length"
+}
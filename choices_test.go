@@ -0,0 +1,96 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const choicesStreamBody = `data: {"choices":[{"index":1,"delta":{"role":"assistant","content":"B"}}]}
+
+data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"A"}}]}
+
+data: {"choices":[{"index":1,"delta":{"content":"b"},"finish_reason":"length"}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"a"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+func TestChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		if req.N == nil || *req.N != 2 {
+			t.Errorf("n = %v", req.N)
+		}
+		if req.Stream {
+			io.WriteString(w, choicesStreamBody)
+			return
+		}
+		w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"A"},"finish_reason":"stop"},{"index":1,"message":{"role":"assistant","content":"B"},"finish_reason":"length"}]}`))
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+
+	resp, err := c.DoOnceN(context.Background(), say, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	choices := resp.AllChoices()
+	if len(choices) != 2 || choices[0].Text != "A" || choices[1].Text != "B" || !choices[1].CanContinue() {
+		t.Errorf("DoOnceN choices = %+v", choices)
+	}
+
+	deltas := map[int]string{}
+	full, err := c.DoStreamN(context.Background(), say, 2, func(delta Choice) {
+		deltas[delta.Index] += delta.Text
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltas[0] != "Aa" || deltas[1] != "Bb" {
+		t.Errorf("deltas = %v", deltas)
+	}
+	if full.Text() != "Aa" || full.CanContinue() {
+		t.Errorf("full = %q, continue %v", full.Text(), full.CanContinue())
+	}
+	choices = full.AllChoices()
+	if len(choices) != 2 || choices[1].Text != "Bb" || choices[1].FinishReason != "length" {
+		t.Errorf("DoStreamN choices = %+v", choices)
+	}
+
+	// 只携带 index 1 的数据块不影响 index 0 的文本
+	var chunk ChatStreamCompletionResponse
+	json.Unmarshal([]byte(`{"choices":[{"index":1,"delta":{"content":"B"},"finish_reason":"length"}]}`), &chunk)
+	if chunk.Text() != "" || chunk.CanContinue() {
+		t.Errorf("chunk text = %q, continue %v", chunk.Text(), chunk.CanContinue())
+	}
+
+	if _, err := c.DoOnceN(context.Background(), say, 0); err == nil {
+		t.Error("DoOnceN(0) succeeded")
+	}
+}
+
+func TestAllChoicesFunctionCall(t *testing.T) {
+	var resp ChatCompletionResponse
+	json.Unmarshal([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","function_call":{"name":"weather","arguments":"{}"}},"finish_reason":"function_call"}]}`), &resp)
+	if choices := resp.AllChoices(); len(choices) != 1 || choices[0].FunctionCall == nil || choices[0].FunctionCall.Name != "weather" {
+		t.Errorf("AllChoices() = %+v", choices)
+	}
+
+	stream := ChatStreamCompletionResponse{Choices: []ChatStreamCompletionResponseChoice{{
+		FinishReason: "function_call",
+		FunctionCall: &FunctionCall{Name: "weather", Arguments: "{}"},
+	}}}
+	if choices := stream.AllChoices(); len(choices) != 1 || choices[0].FunctionCall == nil || choices[0].FunctionCall.Arguments != "{}" {
+		t.Errorf("stream AllChoices() = %+v", choices)
+	}
+}
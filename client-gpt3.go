@@ -1,10 +1,14 @@
 package gpt3
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
+	"github.com/sunreaver/go-gpt3/internal/tiktoken"
 )
 
 type GPT3client struct {
@@ -18,7 +22,24 @@ type GPT3client struct {
 	apikey        string
 	authtoken     string
 	maxretry      int
+	retrypolicy   RetryPolicy
 	defaultEngine EngineType
+
+	maxtooliterations int
+	streamusage       bool
+
+	// 自动续写，见 WithAutoContinue
+	maxcontinue       int
+	maxcontinuetokens int
+	continueprompt    string
+
+	// 历史消息超长时的截断策略，nil 表示 DropOldest
+	truncation TruncationStrategy
+
+	// 内容审核，见 WithModeration
+	moderateinput   bool
+	moderateoutput  bool
+	moderationmodel string
 }
 
 func MakeGPT3Client(options ...ClientOption) *GPT3client {
@@ -28,6 +49,9 @@ func MakeGPT3Client(options ...ClientOption) *GPT3client {
 		maxtokens:     256,
 		maxsend:       4096, // 默认4k
 		stop:          nil,
+
+		maxtooliterations: DefaultMaxToolIterations,
+		continueprompt:    DefaultContinuePrompt,
 	}
 
 	c.client = NewClient(
@@ -37,75 +61,233 @@ func MakeGPT3Client(options ...ClientOption) *GPT3client {
 	return c
 }
 
+// DoStream 流式请求回复，每个数据块调用一次 fn。
+// 开启 WithModeration 的输出审核时，流结束后才能审核完整的回复，未通过时返回 ErrFlaggedContent，调用方需要丢弃已经收到的内容。
 func (c *GPT3client) DoStream(ctx context.Context, say []ChatCompletionMessage, fn func(cr CompletionResponseInterface)) error {
+	if err := c.screenInput(ctx, say); err != nil {
+		return err
+	}
+	var acc *StreamAccumulator
+	if c.moderateoutput {
+		acc = NewStreamAccumulator()
+		onData := fn
+		fn = func(cr CompletionResponseInterface) {
+			acc.Add(cr)
+			onData(cr)
+		}
+	}
+	var err error
+	if c.maxcontinue > 0 {
+		err = c.doStreamContinue(ctx, say, fn)
+	} else {
+		err = c.doStream(ctx, say, 1, c.maxtokens, fn)
+	}
+	if err != nil || acc == nil {
+		return err
+	}
+	return c.screenOutput(ctx, acc.Response())
+}
+
+// DoStreamN 流式请求 n 个候选回复，每个数据块中的每个候选回复的增量调用一次 fn，delta.Index 区分所属的候选回复。
+// 结束后返回拼装好的完整响应，Choices 按 index 排序。
+func (c *GPT3client) DoStreamN(ctx context.Context, say []ChatCompletionMessage, n int, fn func(delta Choice)) (*ChatCompletionResponse, error) {
+	if n < 1 {
+		return nil, errors.Errorf("候选回复数量必须大于0: n=%d", n)
+	}
+	if err := c.screenInput(ctx, say); err != nil {
+		return nil, err
+	}
+	acc := NewStreamAccumulator()
+	err := c.doStream(ctx, say, n, c.maxtokens, func(cr CompletionResponseInterface) {
+		acc.Add(cr)
+		if fn == nil {
+			return
+		}
+		for _, delta := range cr.AllChoices() {
+			fn(delta)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := acc.Response()
+	if err := c.screenOutput(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *GPT3client) doStream(ctx context.Context, say []ChatCompletionMessage, n, maxtokens int, fn func(cr CompletionResponseInterface)) error {
 	if len(say) == 0 {
 		return errors.New("您得说些什么。")
 	}
-	if c.defaultEngine == Gpt35TurboEngine ||
-		c.defaultEngine == Gpt4Engine {
-		request, err := c.makeChatCompletionRequest(ChatCompletionMessage{
+	if c.isChatEngine() {
+		request, err := c.makeChatCompletionRequest(ctx, ChatCompletionMessage{
 			Role:    "system",
 			Content: c.systemprompt,
 		}, say...)
 		if err != nil {
 			return err
 		}
+		request.N = candidates(n)
+		request.MaxTokens = &maxtokens
+		request.StreamOptions = c.streamOptions()
 		return c.client.ChatCompletionStream(ctx, request, fn)
 	}
-	return c.client.CompletionStreamWithEngine(ctx, c.defaultEngine, c.makeCompletionRequest(append([]ChatCompletionMessage{
+	request := c.makeCompletionRequest(append([]ChatCompletionMessage{
+		{
+			Role:    "system",
+			Content: c.systemprompt,
+		},
+	}, say...))
+	request.N = candidates(n)
+	request.MaxTokens = &maxtokens
+	request.StreamOptions = c.streamOptions()
+	return c.client.CompletionStreamWithEngine(ctx, c.defaultEngine, request, fn)
+}
+
+// OpenStream 与 DoStream 相同，但返回 Stream 供调用方读取，而不是回调 fn。
+// 调用方需要 Close 返回的 Stream。WithModeration 只审核输入，不审核 Stream 中的回复。
+func (c *GPT3client) OpenStream(ctx context.Context, say []ChatCompletionMessage) (*Stream, error) {
+	if len(say) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	if err := c.screenInput(ctx, say); err != nil {
+		return nil, err
+	}
+	if c.isChatEngine() {
+		request, err := c.makeChatCompletionRequest(ctx, ChatCompletionMessage{
+			Role:    "system",
+			Content: c.systemprompt,
+		}, say...)
+		if err != nil {
+			return nil, err
+		}
+		request.StreamOptions = c.streamOptions()
+		return c.client.OpenChatCompletionStream(ctx, request)
+	}
+	request := c.makeCompletionRequest(append([]ChatCompletionMessage{
 		{
 			Role:    "system",
 			Content: c.systemprompt,
 		},
-	}, say...)), fn)
+	}, say...))
+	request.StreamOptions = c.streamOptions()
+	return c.client.OpenCompletionStreamWithEngine(ctx, c.defaultEngine, request)
 }
 
 func (c *GPT3client) DoOnce(ctx context.Context, say []ChatCompletionMessage) (CompletionResponseInterface, error) {
+	if err := c.screenInput(ctx, say); err != nil {
+		return nil, err
+	}
+	var (
+		resp CompletionResponseInterface
+		err  error
+	)
+	if c.maxcontinue > 0 {
+		resp, err = c.doOnceContinue(ctx, say)
+	} else {
+		resp, err = c.doOnce(ctx, say, 1, c.maxtokens)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.screenOutput(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DoOnceN 请求 n 个候选回复，通过返回值的 AllChoices 按 index 获取每个候选回复。
+func (c *GPT3client) DoOnceN(ctx context.Context, say []ChatCompletionMessage, n int) (CompletionResponseInterface, error) {
+	if n < 1 {
+		return nil, errors.Errorf("候选回复数量必须大于0: n=%d", n)
+	}
+	if err := c.screenInput(ctx, say); err != nil {
+		return nil, err
+	}
+	resp, err := c.doOnce(ctx, say, n, c.maxtokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.screenOutput(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *GPT3client) doOnce(ctx context.Context, say []ChatCompletionMessage, n, maxtokens int) (CompletionResponseInterface, error) {
 	if len(say) == 0 {
 		return nil, errors.New("您得说些什么。")
 	}
-	if c.defaultEngine == Gpt35TurboEngine ||
-		c.defaultEngine == Gpt4Engine {
-		request, err := c.makeChatCompletionRequest(ChatCompletionMessage{
+	if c.isChatEngine() {
+		request, err := c.makeChatCompletionRequest(ctx, ChatCompletionMessage{
 			Role:    "system",
 			Content: c.systemprompt,
 		}, say...)
 		if err != nil {
 			return nil, err
 		}
+		request.N = candidates(n)
+		request.MaxTokens = &maxtokens
 		return c.client.ChatCompletion(ctx, request)
 	}
-	return c.client.CompletionWithEngine(ctx, c.defaultEngine, c.makeCompletionRequest(append([]ChatCompletionMessage{
+	request := c.makeCompletionRequest(append([]ChatCompletionMessage{
 		{
 			Role:    "system",
 			Content: c.systemprompt,
 		},
-	}, say...)))
+	}, say...))
+	request.N = candidates(n)
+	request.MaxTokens = &maxtokens
+	return c.client.CompletionWithEngine(ctx, c.defaultEngine, request)
 }
 
-func (c *GPT3client) makeChatCompletionRequest(system ChatCompletionMessage, say ...ChatCompletionMessage) (ChatCompletionRequest, error) {
-	// 组装 内容
-	maxlen := c.maxsend - len(system.Content)
-	tmpCount := 0
-CLIP:
-	for i := len(say) - 1; i >= 0; i-- {
-		tmpCount += len(say[i].Content)
-		if tmpCount > maxlen {
-			if i == len(say)-1 {
-				// 第一个就超出
-				return ChatCompletionRequest{}, errors.Errorf("输入内容过长; 最长%v, 当前%v", maxlen, tmpCount)
-			}
-			if tstr := []rune(say[i].Content[tmpCount-maxlen:]); len(tstr) > 2 {
-				// 计算可以补多少内容
-				// 截取部分，而不是丢失全部
-				say[i].Content = string(tstr[2:])
-				say = say[i:]
-			} else {
-				// 补得内容过少，则直接丢弃
-				say = say[i+1:]
-			}
-			break CLIP
-		}
+// candidates 请求的候选回复数量；1 个时不设置，使用接口默认值
+func candidates(n int) *int {
+	if n <= 1 {
+		return nil
+	}
+	return &n
+}
+
+// RateLimit 返回最近一次响应头中的限流状态
+func (c *GPT3client) RateLimit() RateLimitState {
+	return c.client.RateLimit()
+}
+
+// streamOptions 流式请求的选项；开启 WithStreamUsage 时要求返回用量
+func (c *GPT3client) streamOptions() *StreamOptions {
+	if !c.streamusage {
+		return nil
+	}
+	return &StreamOptions{IncludeUsage: true}
+}
+
+// retryPolicy 返回重试策略；未通过 WithRetryPolicy 指定时，按 WithMaxRetry 的次数使用默认策略
+func (c *GPT3client) retryPolicy() RetryPolicy {
+	if c.retrypolicy != nil {
+		return c.retrypolicy
+	}
+	return DefaultRetryPolicy(c.maxretry)
+}
+
+// isChatEngine 默认引擎是否走 chat/completions 接口
+func (c *GPT3client) isChatEngine() bool {
+	return c.defaultEngine == Gpt35TurboEngine ||
+		c.defaultEngine == Gpt4Engine
+}
+
+func (c *GPT3client) makeChatCompletionRequest(ctx context.Context, system ChatCompletionMessage, say ...ChatCompletionMessage) (ChatCompletionRequest, error) {
+	// 组装 内容；长度按 token 计算，包括每条消息的格式开销，超出时按 WithTruncation 的策略截断
+	maxlen := c.maxsend - tiktoken.TokensReplyPriming - countMessageTokens(system)
+	truncation := c.truncation
+	if truncation == nil {
+		truncation = DropOldest()
+	}
+	say, err := truncation.Truncate(ctx, say, maxlen)
+	if err != nil {
+		return ChatCompletionRequest{}, err
 	}
 	return ChatCompletionRequest{
 		Model:     c.defaultEngine,
@@ -126,11 +308,7 @@ func (c *GPT3client) makeCompletionRequest(say []ChatCompletionMessage) Completi
 			text.WriteString(v.Content)
 		}
 	}
-	syslen := len(system)
-	tstr := text.String()
-	if maxlen, l := c.maxsend-syslen, len(tstr); l > maxlen {
-		tstr = string([]rune(tstr[l-maxlen:])[2:])
-	}
+	tstr := clipTokens(text.String(), c.maxsend-countTokens(system))
 	return CompletionRequest{
 		Prompt:    []string{system + tstr},
 		MaxTokens: &c.maxtokens,
@@ -147,3 +325,158 @@ func (c *GPT3client) CreateImage(ctx context.Context, say CreateImageReq) (*Crea
 	}
 	return c.client.CreateImage(ctx, say)
 }
+
+// EditImage 按照描述修改图片中 mask 透明的区域；没有 mask 时使用 image 本身透明的区域。
+// image 及 mask 需要是不超过4MB的 PNG 图片，image 需要是正方形，mask 需要与 image 尺寸相同。
+func (c *GPT3client) EditImage(ctx context.Context, say EditImageReq) (*CreateImageResp, error) {
+	if len(say.Prompt) == 0 {
+		return nil, errors.New("您得说些什么。")
+	} else if utf8.RuneCountInString(say.Prompt) > 1000 {
+		return nil, errors.New("描述过长，最多1000个字符。")
+	}
+	if err := checkImageOptions(&say.N, say.Size); err != nil {
+		return nil, err
+	}
+	data, config, err := readPNG(say.Image, "原")
+	if err != nil {
+		return nil, err
+	}
+	if config.Width != config.Height {
+		return nil, errors.Errorf("原图片需要是正方形: %vx%v", config.Width, config.Height)
+	}
+	say.Image = bytes.NewReader(data)
+	if say.Mask != nil {
+		mask, maskConfig, err := readPNG(say.Mask, "mask")
+		if err != nil {
+			return nil, err
+		}
+		if maskConfig.Width != config.Width || maskConfig.Height != config.Height {
+			return nil, errors.Errorf("mask 图片需要与原图片尺寸相同: %vx%v, 原图片 %vx%v", maskConfig.Width, maskConfig.Height, config.Width, config.Height)
+		}
+		say.Mask = bytes.NewReader(mask)
+	}
+	return c.client.EditImage(ctx, say)
+}
+
+// CreateImageVariation 生成图片的变体；image 需要是不超过4MB的正方形 PNG 图片。
+func (c *GPT3client) CreateImageVariation(ctx context.Context, say ImageVariationReq) (*CreateImageResp, error) {
+	if err := checkImageOptions(&say.N, say.Size); err != nil {
+		return nil, err
+	}
+	data, config, err := readPNG(say.Image, "原")
+	if err != nil {
+		return nil, err
+	}
+	if config.Width != config.Height {
+		return nil, errors.Errorf("原图片需要是正方形: %vx%v", config.Width, config.Height)
+	}
+	say.Image = bytes.NewReader(data)
+	return c.client.CreateImageVariation(ctx, say)
+}
+
+// Embeddings 获取文本向量；未指定模型时使用 TextEmbeddingAda002。
+func (c *GPT3client) Embeddings(ctx context.Context, say EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	if len(say.Input) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	if len(say.Model) == 0 {
+		say.Model = TextEmbeddingAda002
+	}
+	return c.client.Embeddings(ctx, say)
+}
+
+// Moderate 审核输入内容是否违反使用政策；未通过 WithModeration 指定模型时使用接口默认的模型。
+func (c *GPT3client) Moderate(ctx context.Context, input ...string) (*ModerationResponse, error) {
+	if len(input) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	return c.client.Moderations(ctx, ModerationRequest{
+		Input: input,
+		Model: c.moderationmodel,
+	})
+}
+
+// Transcribe 把音频转写为文字；未指定模型时使用 WhisperModel。
+func (c *GPT3client) Transcribe(ctx context.Context, say AudioRequest) (*AudioResponse, error) {
+	if err := checkAudioRequest(&say); err != nil {
+		return nil, err
+	}
+	return c.client.CreateTranscription(ctx, say)
+}
+
+// Translate 把音频翻译为英文；未指定模型时使用 WhisperModel，Language 不起作用。
+func (c *GPT3client) Translate(ctx context.Context, say AudioRequest) (*AudioResponse, error) {
+	if err := checkAudioRequest(&say); err != nil {
+		return nil, err
+	}
+	return c.client.CreateTranslation(ctx, say)
+}
+
+// Speech 把文字转换为语音，返回的音频边生成边读取，调用方需要 Close。
+// 未指定模型时使用 TTSModel，未指定声音时使用 VoiceAlloy。
+func (c *GPT3client) Speech(ctx context.Context, say SpeechRequest) (io.ReadCloser, error) {
+	if len(say.Input) == 0 {
+		return nil, errors.New("您得说些什么。")
+	} else if utf8.RuneCountInString(say.Input) > 4096 {
+		return nil, errors.New("内容过长，最多4096个字符。")
+	} else if say.Speed != 0 && (say.Speed < 0.25 || say.Speed > 4) {
+		return nil, errors.Errorf("语速需要在0.25到4之间: speed=%v", say.Speed)
+	}
+	if len(say.Model) == 0 {
+		say.Model = TTSModel
+	}
+	if len(say.Voice) == 0 {
+		say.Voice = VoiceAlloy
+	}
+	return c.client.CreateSpeech(ctx, say)
+}
+
+func checkAudioRequest(say *AudioRequest) error {
+	if say.File == nil {
+		return errors.New("您得说些什么。")
+	}
+	if len(say.FileName) == 0 {
+		return errors.New("需要音频的文件名，接口按扩展名识别音频格式。")
+	}
+	if len(say.Model) == 0 {
+		say.Model = WhisperModel
+	}
+	return nil
+}
+
+// Edits 按照指令修改输入内容；未指定模型时使用 DefaultEditsModel。
+func (c *GPT3client) Edits(ctx context.Context, say EditsRequest) (*EditsResponse, error) {
+	if len(say.Instruction) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	if len(say.Model) == 0 {
+		say.Model = DefaultEditsModel
+	}
+	return c.client.Edits(ctx, say)
+}
+
+// Engines 列出当前可用的引擎。
+func (c *GPT3client) Engines(ctx context.Context) (*EnginesResponse, error) {
+	return c.client.Engines(ctx)
+}
+
+// Engine 获取引擎信息；engine 为空时使用默认引擎。
+func (c *GPT3client) Engine(ctx context.Context, engine EngineType) (*EngineObject, error) {
+	if len(engine) == 0 {
+		engine = c.defaultEngine
+	}
+	return c.client.Engine(ctx, engine)
+}
+
+// Search 使用默认引擎在文档中做语义搜索。
+func (c *GPT3client) Search(ctx context.Context, say SearchRequest) (*SearchResponse, error) {
+	return c.SearchWithEngine(ctx, c.defaultEngine, say)
+}
+
+// SearchWithEngine 使用指定引擎在文档中做语义搜索。
+func (c *GPT3client) SearchWithEngine(ctx context.Context, engine EngineType, say SearchRequest) (*SearchResponse, error) {
+	if len(say.Query) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	return c.client.SearchWithEngine(ctx, engine, say)
+}
@@ -0,0 +1,102 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGPT3clientEndpoints(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /engines":
+			io.WriteString(w, `{"object":"list","data":[{"id":"gpt-4","object":"engine","ready":true}]}`)
+		case "GET /engines/gpt-4":
+			io.WriteString(w, `{"id":"gpt-4","object":"engine","owner":"openai","ready":true}`)
+		case "POST /edits":
+			var req EditsRequest
+			json.Unmarshal(body, &req)
+			if req.Model != DefaultEditsModel || req.Instruction != "fix" {
+				t.Errorf("edits = %+v", req)
+			}
+			if req.Input == "fail" {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error":{"message":"bad input","type":"invalid_request_error"}}`)
+				return
+			}
+			io.WriteString(w, `{"object":"edit","choices":[{"text":"fixed","index":0}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`)
+		case "POST /embeddings":
+			var req EmbeddingsRequest
+			json.Unmarshal(body, &req)
+			if req.Model != TextEmbeddingAda002 || len(req.Input) != 2 {
+				t.Errorf("embeddings = %+v", req)
+			}
+			io.WriteString(w, `{"object":"list","data":[{"index":0,"embedding":[0.1,0.2]},{"index":1,"embedding":[0.3,0.4]}],"usage":{"prompt_tokens":4,"total_tokens":4}}`)
+		case "POST /engines/gpt-4/search":
+			var req SearchRequest
+			json.Unmarshal(body, &req)
+			if req.Query != "q" || len(req.Documents) != 2 {
+				t.Errorf("search = %+v", req)
+			}
+			io.WriteString(w, `{"object":"list","data":[{"document":1,"object":"search_result","score":0.9}]}`)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(Gpt4Engine), WithMaxRetry(1))
+
+	engines, err := c.Engines(ctx)
+	if err != nil || len(engines.Data) != 1 || !engines.Data[0].Ready {
+		t.Errorf("Engines = %+v, %v", engines, err)
+	}
+	// engine 为空时使用默认引擎
+	if engine, err := c.Engine(ctx, ""); err != nil || engine.Owner != "openai" {
+		t.Errorf("Engine = %+v, %v", engine, err)
+	}
+
+	// 未指定模型时使用 DefaultEditsModel
+	edits, err := c.Edits(ctx, EditsRequest{Input: "teh", Instruction: "fix"})
+	if err != nil || edits.Choices[0].Text != "fixed" || edits.Usage.TotalTokens != 7 {
+		t.Errorf("Edits = %+v, %v", edits, err)
+	}
+	var apiErr APIError
+	if _, err := c.Edits(ctx, EditsRequest{Input: "fail", Instruction: "fix"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Edits error = %v", err)
+	}
+
+	// 未指定模型时使用 TextEmbeddingAda002
+	embeddings, err := c.Embeddings(ctx, EmbeddingsRequest{Input: []string{"a", "b"}})
+	if err != nil || len(embeddings.Data) != 2 || embeddings.Usage.PromptTokens != 4 {
+		t.Errorf("Embeddings = %+v, %v", embeddings, err)
+	}
+
+	search, err := c.Search(ctx, SearchRequest{Query: "q", Documents: []string{"x", "y"}})
+	if err != nil || len(search.Data) != 1 || search.Data[0].Document != 1 {
+		t.Errorf("Search = %+v, %v", search, err)
+	}
+
+	// 空输入在发送前拒绝
+	sent := requests
+	if _, err := c.Edits(ctx, EditsRequest{Input: "x"}); err == nil {
+		t.Error("Edits without instruction succeeded")
+	}
+	if _, err := c.Embeddings(ctx, EmbeddingsRequest{}); err == nil {
+		t.Error("Embeddings without input succeeded")
+	}
+	if _, err := c.SearchWithEngine(ctx, Gpt4Engine, SearchRequest{Documents: []string{"x"}}); err == nil {
+		t.Error("Search without query succeeded")
+	}
+	if requests != sent {
+		t.Errorf("%v requests sent for invalid input", requests-sent)
+	}
+}
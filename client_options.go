@@ -1,8 +1,12 @@
 package gpt3
 
 import (
+	"log/slog"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ClientOption are options that can be passed when creating a new client
@@ -78,7 +82,8 @@ func WithMaxtokens(maxtokens int) ClientOption {
 	}
 }
 
-// maxsend
+// maxsend 发送内容的最大 token 数，包括系统提示及每条消息的格式开销。
+// 超出时丢弃较早的消息。
 func WithMaxsend(maxsend int) ClientOption {
 	return func(c *client) error {
 		c.gpt3.maxsend = maxsend
@@ -120,7 +125,7 @@ func WithAuthtoken(authtoken string) ClientOption {
 	}
 }
 
-// WithMaxRetry 注入重试次数。
+// WithMaxRetry 注入最多尝试次数(包括第一次)，使用默认的重试策略。
 func WithMaxRetry(try int) ClientOption {
 	if try < 1 {
 		try = DefaultRetry
@@ -131,3 +136,159 @@ func WithMaxRetry(try int) ClientOption {
 		return nil
 	}
 }
+
+// WithRateLimiter 开启客户端限流，按每分钟请求数及每分钟 token 数控制 chat、completion、embeddings 及流式请求的速度；
+// 发送前按提示的 token 数加上最多生成的 token 数预估消耗。参数小于等于0表示该项不限制。
+// 服务端返回的 x-ratelimit-remaining-* 会用于校正本地的估计。
+func WithRateLimiter(requestsPerMinute, tokensPerMinute int) ClientOption {
+	return func(c *client) error {
+		c.limiter = newRateLimiter(requestsPerMinute, tokensPerMinute)
+		return nil
+	}
+}
+
+// WithLogger 注入日志。每次尝试以 Info 级别记录接口、模型、token 数、耗时、状态码及第几次尝试，失败时为 Warn 级别；
+// Debug 级别另外记录请求及响应的内容，内容经过 WithRedactor 的脱敏函数处理。
+// Authorization 及 api-key 请求头总是被遮盖。
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithRedactor 注入日志的脱敏函数，默认为 RedactSecrets
+func WithRedactor(redact Redactor) ClientOption {
+	return func(c *client) error {
+		c.redactor = redact
+		return nil
+	}
+}
+
+// WithOpenTelemetry 开启 OpenTelemetry：为 chat、completion、embeddings 及图片接口(包括流式接口)记录 span，
+// 属性遵循 GenAI 语义约定；并记录耗时、token 用量、流式响应首个数据块耗时及重试次数的指标。
+// tp、mp 为 nil 时使用 otel 全局的 provider。
+func WithOpenTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) ClientOption {
+	return func(c *client) error {
+		c.telemetry = newTelemetry(tp, mp)
+		return nil
+	}
+}
+
+// WithMiddleware 注入中间件，可以多次调用；先注入的中间件在外层。
+// 中间件位于重试之内，每次尝试都会调用，可以读取解码前后的请求与响应以及原始的 HTTP 请求与响应。
+func WithMiddleware(middleware ...Middleware) ClientOption {
+	return func(c *client) error {
+		c.middleware = append(c.middleware, middleware...)
+		return nil
+	}
+}
+
+// WithStreamUsage DoStream 及 OpenStream 是否通过 stream_options.include_usage 要求返回用量，默认关闭。
+// 开启时最后一个数据块的 choices 为空，只携带整个请求的用量；旧的 completions 接口及部分 Azure api-version 不支持 stream_options，会返回400。
+func WithStreamUsage(include bool) ClientOption {
+	return func(c *client) error {
+		c.gpt3.streamusage = include
+		return nil
+	}
+}
+
+// WithPricing 注入价格表，用于计算 UsageRecorder 的花费及 WithBudget 的预算，默认为 DefaultPricing。
+func WithPricing(pricing Pricing) ClientOption {
+	return func(c *client) error {
+		c.pricing = pricing
+		return nil
+	}
+}
+
+// WithBudget 注入预算，按 ContextWithTenant 传入的租户限制每天及每月的花费。
+// chat、completion 及 embeddings 请求发送前检查预算，超出时返回 ErrBudgetExceeded；请求完成后按实际用量计入花费。
+// 流式请求需要开启 WithStreamUsage 才能计入花费。
+func WithBudget(budget *Budget) ClientOption {
+	return func(c *client) error {
+		c.budget = budget
+		return nil
+	}
+}
+
+// WithRetryPolicy 注入重试策略，覆盖 WithMaxRetry 的设置。
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *client) error {
+		c.gpt3.retrypolicy = policy
+		return nil
+	}
+}
+
+// WithMaxToolIterations 注入 DoWithTools 的最大工具调用轮数。
+func WithMaxToolIterations(n int) ClientOption {
+	if n < 1 {
+		n = DefaultMaxToolIterations
+	}
+
+	return func(c *client) error {
+		c.gpt3.maxtooliterations = n
+		return nil
+	}
+}
+
+// WithAutoContinue 开启自动续写：DoOnce 及 DoStream 的回复因为 max_tokens 被截断(finish_reason 为 "length")时，
+// 把已经生成的部分作为 assistant 消息追加到对话中，请模型接着写，最后拼接成一个完整的回复。
+// maxContinuations 最多续写的次数，0 表示不续写(默认)；maxTokens 拼接后的回复最多的 token 数，0 表示不限制。
+// 请求续写的用户消息见 WithContinuePrompt。
+func WithAutoContinue(maxContinuations, maxTokens int) ClientOption {
+	if maxContinuations < 0 {
+		maxContinuations = 0
+	}
+	if maxTokens < 0 {
+		maxTokens = 0
+	}
+
+	return func(c *client) error {
+		c.gpt3.maxcontinue = maxContinuations
+		c.gpt3.maxcontinuetokens = maxTokens
+		return nil
+	}
+}
+
+// WithTruncation 指定历史消息超出 WithMaxsend 的长度时的截断策略，默认为 DropOldest。
+// 可选 DropOldest、KeepFirstLast、SummarizeOlder 或自定义的 TruncationStrategy；
+// SummarizeTruncation 未设置 Summarize 时使用本客户端的模型生成摘要；此时客户端使用策略的副本，不修改传入的策略，
+// 同一个策略可以传给多个客户端，各自使用自己的模型生成摘要。
+func WithTruncation(strategy TruncationStrategy) ClientOption {
+	return func(c *client) error {
+		if s, ok := strategy.(*SummarizeTruncation); ok && s.Summarize == nil {
+			strategy = &SummarizeTruncation{
+				KeepLast:  s.KeepLast,
+				Prompt:    s.Prompt,
+				Summarize: c.gpt3.summarize,
+			}
+		}
+		c.gpt3.truncation = strategy
+		return nil
+	}
+}
+
+// WithContinuePrompt 指定自动续写时请求模型继续的用户消息，默认为 DefaultContinuePrompt；
+// 对话不是中文时可以换成对话所用的语言。空字符串表示使用默认值。
+func WithContinuePrompt(prompt string) ClientOption {
+	if len(prompt) == 0 {
+		prompt = DefaultContinuePrompt
+	}
+
+	return func(c *client) error {
+		c.gpt3.continueprompt = prompt
+		return nil
+	}
+}
+
+// WithModeration 开启内容审核：input 为 true 时，DoOnce、DoStream 等发送前先审核本轮的用户消息；
+// output 为 true 时审核模型的回复。未通过审核时返回 *FlaggedContentError，errors.Is(err, ErrFlaggedContent) 为 true。
+// model 为审核使用的模型，空表示接口默认的模型。
+func WithModeration(input, output bool, model string) ClientOption {
+	return func(c *client) error {
+		c.gpt3.moderateinput = input
+		c.gpt3.moderateoutput = output
+		c.gpt3.moderationmodel = model
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package gpt3
+
+import (
+	"context"
+	"strings"
+)
+
+// DefaultContinuePrompt 续写时默认追加的用户消息，见 WithContinuePrompt
+const DefaultContinuePrompt = "请从上次中断的地方继续，不要重复已经输出的内容。"
+
+// continuation 自动续写过程中的状态
+type continuation struct {
+	say       []ChatCompletionMessage
+	text      strings.Builder
+	usage     Usage
+	generated int
+	rounds    int
+}
+
+// messages 下一轮请求的消息；已有回复时追加为 assistant 消息，chat 模型再追加一条内容为 prompt 的用户消息请求续写
+func (cont *continuation) messages(chat bool, prompt string) []ChatCompletionMessage {
+	if cont.text.Len() == 0 {
+		return cont.say
+	}
+	say := append(append([]ChatCompletionMessage(nil), cont.say...), ChatCompletionMessage{
+		Role:    ChatMessageRoleAssistant,
+		Content: cont.text.String(),
+	})
+	if chat {
+		say = append(say, ChatCompletionMessage{
+			Role:    ChatMessageRoleUser,
+			Content: prompt,
+		})
+	}
+	return say
+}
+
+// add 记录一轮的回复
+func (cont *continuation) add(resp CompletionResponseInterface) {
+	text := resp.Text()
+	cont.text.WriteString(text)
+	usage := resp.TokenUsage()
+	cont.usage = cont.usage.Add(usage)
+	if usage.CompletionTokens > 0 {
+		cont.generated += usage.CompletionTokens
+	} else {
+		// 流式请求未返回用量时按内容估计
+		cont.generated += countTokens(text)
+	}
+	cont.rounds++
+}
+
+// continueMaxTokens 下一轮的 max_tokens；不超过拼接后回复的 token 上限，已达上限时返回0
+func (c *GPT3client) continueMaxTokens(cont *continuation) int {
+	if c.maxcontinuetokens == 0 {
+		return c.maxtokens
+	}
+	left := c.maxcontinuetokens - cont.generated
+	if left <= 0 {
+		return 0
+	}
+	if left < c.maxtokens {
+		return left
+	}
+	return c.maxtokens
+}
+
+// shouldContinue 回复被截断，且未达到续写次数及 token 上限时继续
+func (c *GPT3client) shouldContinue(cont *continuation, resp CompletionResponseInterface) bool {
+	return resp.CanContinue() &&
+		cont.rounds <= c.maxcontinue &&
+		c.continueMaxTokens(cont) > 0
+}
+
+func (c *GPT3client) doOnceContinue(ctx context.Context, say []ChatCompletionMessage) (CompletionResponseInterface, error) {
+	cont := &continuation{say: say}
+	for {
+		resp, err := c.doOnce(ctx, cont.messages(c.isChatEngine(), c.continueprompt), 1, c.continueMaxTokens(cont))
+		if err != nil {
+			return nil, err
+		}
+		cont.add(resp)
+		if !c.shouldContinue(cont, resp) {
+			return stitchResponse(resp, cont.text.String(), cont.usage), nil
+		}
+	}
+}
+
+func (c *GPT3client) doStreamContinue(ctx context.Context, say []ChatCompletionMessage, fn func(cr CompletionResponseInterface)) error {
+	cont := &continuation{say: say}
+	for {
+		acc := NewStreamAccumulator()
+		err := c.doStream(ctx, cont.messages(c.isChatEngine(), c.continueprompt), 1, c.continueMaxTokens(cont), func(cr CompletionResponseInterface) {
+			acc.Add(cr)
+			fn(cr)
+		})
+		if err != nil {
+			return err
+		}
+		resp := acc.Response()
+		cont.add(resp)
+		if !c.shouldContinue(cont, resp) {
+			return nil
+		}
+	}
+}
+
+// stitchResponse 把最后一轮的响应改为拼接后的完整回复及所有轮次的用量之和
+func stitchResponse(last CompletionResponseInterface, text string, usage Usage) CompletionResponseInterface {
+	switch r := last.(type) {
+	case *ChatCompletionResponse:
+		if len(r.Choices) > 0 {
+			r.Choices[0].Message.Content = text
+		}
+		r.Usage = usage
+	case *CompletionResponse:
+		if len(r.Choices) > 0 {
+			r.Choices[0].Text = text
+		}
+		r.Usage = usage
+	}
+	return last
+}
@@ -0,0 +1,103 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutoContinue(t *testing.T) {
+	pieces := []string{"one ", "two ", "three"}
+	wantPrompt := DefaultContinuePrompt
+	var maxTokens []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		maxTokens = append(maxTokens, *req.MaxTokens)
+
+		// 第 i 轮的消息: system, user, [assistant, user(续写)]
+		round := 0
+		if n := len(req.Messages); n > 2 {
+			if req.Messages[n-1].Content != wantPrompt {
+				t.Errorf("last message = %+v", req.Messages[n-1])
+			}
+			switch req.Messages[n-2].Content {
+			case "one ":
+				round = 1
+			case "one two ":
+				round = 2
+			default:
+				t.Errorf("assistant message = %q", req.Messages[n-2].Content)
+			}
+		}
+		finish := "length"
+		if round == len(pieces)-1 {
+			finish = "stop"
+		}
+		if req.Stream {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":%q}]}\n\n", pieces[round], finish)
+			fmt.Fprintf(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":4,\"total_tokens\":14}}\n\ndata: [DONE]\n\n")
+			return
+		}
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":%q}],"usage":{"prompt_tokens":10,"completion_tokens":4,"total_tokens":14}}`, pieces[round], finish)
+	}))
+	defer server.Close()
+
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "count"}}
+
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithMaxtokens(4), WithAutoContinue(5, 0))
+	resp, err := c.DoOnce(context.Background(), say)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "one two three" || resp.CanContinue() {
+		t.Errorf("DoOnce = %q, continue %v", resp.Text(), resp.CanContinue())
+	}
+	if u := resp.TokenUsage(); u.PromptTokens != 30 || u.CompletionTokens != 12 {
+		t.Errorf("usage = %+v", u)
+	}
+
+	var streamed string
+	err = c.DoStream(context.Background(), say, func(cr CompletionResponseInterface) {
+		streamed += cr.Text()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed != "one two three" {
+		t.Errorf("DoStream = %q", streamed)
+	}
+
+	// 续写次数上限
+	c = MakeGPT3Client(WithBaseURL(server.URL), WithMaxtokens(4), WithAutoContinue(1, 0))
+	resp, err = c.DoOnce(context.Background(), say)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "one two " || !resp.CanContinue() {
+		t.Errorf("max continuations = %q, continue %v", resp.Text(), resp.CanContinue())
+	}
+
+	// token 上限，最后一轮的 max_tokens 只剩余量
+	maxTokens = nil
+	c = MakeGPT3Client(WithBaseURL(server.URL), WithMaxtokens(4), WithAutoContinue(5, 6))
+	resp, err = c.DoOnce(context.Background(), say)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "one two " || fmt.Sprint(maxTokens) != "[4 2]" {
+		t.Errorf("max tokens = %q, max_tokens %v", resp.Text(), maxTokens)
+	}
+
+	// 自定义续写提示
+	wantPrompt = "Please continue."
+	c = MakeGPT3Client(WithBaseURL(server.URL), WithMaxtokens(4), WithAutoContinue(5, 0), WithContinuePrompt(wantPrompt))
+	if resp, err := c.DoOnce(context.Background(), say); err != nil || resp.Text() != "one two three" {
+		t.Errorf("custom prompt = %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,135 @@
+package gpt3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Conversation 绑定 GPT3client 的一个对话，自动记录用户及模型的每轮消息并保存到 HistoryStore。
+// 发送时带上所有历史消息，超出 WithMaxsend 的长度时按 WithTruncation 的策略截断，HistoryStore 中仍保留完整历史。
+// 请求失败时本轮消息不保存。同一个 Conversation 的请求按顺序执行，可以并发调用。
+//
+//	conv := client.NewConversation(userID, store)
+//	resp, err := conv.Say(ctx, "你好")
+type Conversation struct {
+	client *GPT3client
+	id     string
+	store  HistoryStore
+
+	mu sync.Mutex
+}
+
+// NewConversation 创建对话；store 为 nil 时使用新的 MemoryHistoryStore。
+// 使用同一个 id 及 HistoryStore 创建的对话共享历史，可以在进程重启后继续。
+func (c *GPT3client) NewConversation(id string, store HistoryStore) *Conversation {
+	if store == nil {
+		store = NewMemoryHistoryStore()
+	}
+	return &Conversation{
+		client: c,
+		id:     id,
+		store:  store,
+	}
+}
+
+// ID 返回对话 id
+func (cv *Conversation) ID() string {
+	return cv.id
+}
+
+// History 返回对话的所有历史消息
+func (cv *Conversation) History(ctx context.Context) ([]ChatCompletionMessage, error) {
+	return cv.store.Load(ctx, cv.id)
+}
+
+// Reset 清空对话的历史消息
+func (cv *Conversation) Reset(ctx context.Context) error {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	return cv.store.Clear(ctx, cv.id)
+}
+
+// Say 发送用户消息，返回模型的回复；用户消息及回复追加到历史
+func (cv *Conversation) Say(ctx context.Context, content string) (CompletionResponseInterface, error) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	say, err := cv.prepare(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cv.client.DoOnce(ctx, say)
+	if err != nil {
+		return nil, err
+	}
+	if err := cv.save(ctx, say[len(say)-1], resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// SayStream 与 Say 相同，但以流式返回回复，每个数据块调用一次 fn；流结束后回复追加到历史
+func (cv *Conversation) SayStream(ctx context.Context, content string, fn func(cr CompletionResponseInterface)) error {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	say, err := cv.prepare(ctx, content)
+	if err != nil {
+		return err
+	}
+	acc := NewStreamAccumulator()
+	err = cv.client.DoStream(ctx, say, func(cr CompletionResponseInterface) {
+		acc.Add(cr)
+		fn(cr)
+	})
+	if err != nil {
+		return err
+	}
+	return cv.save(ctx, say[len(say)-1], acc.Response())
+}
+
+// prepare 读取历史并追加本轮的用户消息
+func (cv *Conversation) prepare(ctx context.Context, content string) ([]ChatCompletionMessage, error) {
+	if len(content) == 0 {
+		return nil, errors.New("您得说些什么。")
+	}
+	history, err := cv.store.Load(ctx, cv.id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取对话历史失败:id=%v", cv.id)
+	}
+	return append(history, ChatCompletionMessage{
+		Role:    ChatMessageRoleUser,
+		Content: content,
+	}), nil
+}
+
+// save 保存本轮的用户消息及回复
+func (cv *Conversation) save(ctx context.Context, user ChatCompletionMessage, resp CompletionResponseInterface) error {
+	if err := cv.store.Append(ctx, cv.id, user, replyMessage(resp)); err != nil {
+		return errors.Wrapf(err, "保存对话历史失败:id=%v", cv.id)
+	}
+	return nil
+}
+
+// replyMessage 把回复转换为完整的 assistant 消息，包括工具调用，使保存的历史与发送的对话一致
+func replyMessage(resp CompletionResponseInterface) ChatCompletionMessage {
+	if r, ok := resp.(*ChatCompletionResponse); ok && r != nil && len(r.Choices) > 0 {
+		msg := r.Choices[0].Message
+		role := msg.Role
+		if len(role) == 0 {
+			role = ChatMessageRoleAssistant
+		}
+		return ChatCompletionMessage{
+			Role:         role,
+			Content:      msg.Content,
+			ToolCalls:    msg.ToolCalls,
+			FunctionCall: msg.FunctionCall,
+		}
+	}
+	return ChatCompletionMessage{
+		Role:    ChatMessageRoleAssistant,
+		Content: resp.Text(),
+	}
+}
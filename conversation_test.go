@@ -0,0 +1,103 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConversation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		// 回复收到的消息数(不含 system)
+		reply := fmt.Sprint(len(req.Messages) - 1)
+		if req.Stream {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":%q},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n", reply)
+			return
+		}
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, reply)
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := c.NewConversation("user/1", store)
+	if resp, err := conv.Say(ctx, "a"); err != nil || resp.Text() != "1" {
+		t.Fatalf("Say = %v, %v", resp, err)
+	}
+	var streamed string
+	if err := conv.SayStream(ctx, "b", func(cr CompletionResponseInterface) { streamed += cr.Text() }); err != nil || streamed != "3" {
+		t.Fatalf("SayStream = %q, %v", streamed, err)
+	}
+
+	// 重新打开目录后继续对话
+	store, err = NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv = c.NewConversation("user/1", store)
+	if resp, err := conv.Say(ctx, "c"); err != nil || resp.Text() != "5" {
+		t.Fatalf("Say after reload = %v, %v", resp, err)
+	}
+	history, err := conv.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(history) != fmt.Sprint([]ChatCompletionMessage{
+		{Role: "user", Content: "a"}, {Role: "assistant", Content: "1"},
+		{Role: "user", Content: "b"}, {Role: "assistant", Content: "3"},
+		{Role: "user", Content: "c"}, {Role: "assistant", Content: "5"},
+	}) {
+		t.Errorf("history = %+v", history)
+	}
+
+	// 写入中断的最后一行被忽略
+	f, _ := os.OpenFile(filepath.Join(dir, "user%2F1.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"role":"us`)
+	f.Close()
+	if history, err := conv.History(ctx); err != nil || len(history) != 6 {
+		t.Errorf("history with partial line = %v, %v", len(history), err)
+	}
+
+	if err := conv.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := conv.History(ctx); len(history) != 0 {
+		t.Errorf("history after reset = %+v", history)
+	}
+
+	// 内存存储，不同对话互不影响
+	mem := NewMemoryHistoryStore()
+	c.NewConversation("x", mem).Say(ctx, "a")
+	if resp, err := c.NewConversation("y", mem).Say(ctx, "a"); err != nil || resp.Text() != "1" {
+		t.Errorf("memory Say = %v, %v", resp, err)
+	}
+
+	// 保存完整的 assistant 消息，包括工具调用
+	tools := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer tools.Close()
+	conv = MakeGPT3Client(WithBaseURL(tools.URL)).NewConversation("z", mem)
+	if _, err := conv.Say(ctx, "weather?"); err != nil {
+		t.Fatal(err)
+	}
+	history, _ = conv.History(ctx)
+	if len(history) != 2 || len(history[1].ToolCalls) != 1 || history[1].ToolCalls[0].ID != "call_1" {
+		t.Errorf("history with tool calls = %+v", history)
+	}
+}
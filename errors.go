@@ -0,0 +1,100 @@
+package gpt3
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Errors reported by the API. Use errors.Is to test for them, and errors.As with an APIError
+// to get the details, e.g. the request ID or the rate limit state:
+//
+//	if errors.Is(err, gpt3.ErrContextLengthExceeded) {
+//		// 提示用户缩短内容
+//	}
+var (
+	// ErrRateLimited the request or token rate limit was hit (429)
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded the account ran out of credits, retrying does not help (429)
+	ErrQuotaExceeded = errors.New("insufficient quota")
+	// ErrContextLengthExceeded the prompt plus max_tokens exceeds the context window of the model
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	// ErrInvalidAuth the api key or token is missing, invalid or revoked (401)
+	ErrInvalidAuth = errors.New("invalid authentication")
+	// ErrContentFiltered the prompt or the completion was rejected by the content filter
+	ErrContentFiltered = errors.New("content filtered")
+	// ErrServerOverloaded the server failed or is overloaded (5xx)
+	ErrServerOverloaded = errors.New("server overloaded")
+	// ErrBudgetExceeded the tenant ran out of budget, see WithBudget. The request was not sent.
+	ErrBudgetExceeded = errors.New("budget exceeded")
+	// ErrUnpricedModel a Budget is set but the model has no price, see Budget.FallbackPrice. The request was not sent.
+	ErrUnpricedModel = errors.New("model has no price")
+	// ErrFlaggedContent the user input or the model output was flagged by moderation, see WithModeration.
+	// Use errors.As with a *FlaggedContentError to get the categories.
+	ErrFlaggedContent = errors.New("flagged content")
+)
+
+// Is makes errors.Is(err, ErrXXX) work for API errors.
+func (e APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests && !e.isQuotaExceeded()
+	case ErrQuotaExceeded:
+		return e.isQuotaExceeded()
+	case ErrContextLengthExceeded:
+		return e.Code == "context_length_exceeded" ||
+			strings.Contains(e.Message, "maximum context length")
+	case ErrInvalidAuth:
+		return e.StatusCode == http.StatusUnauthorized ||
+			e.Code == "invalid_api_key"
+	case ErrContentFiltered:
+		return e.Code == "content_filter" ||
+			e.Code == "content_policy_violation"
+	case ErrServerOverloaded:
+		return e.StatusCode >= http.StatusInternalServerError ||
+			e.Type == "server_error"
+	}
+	return false
+}
+
+func (e APIError) isQuotaExceeded() bool {
+	return e.Type == "insufficient_quota" || e.Code == "insufficient_quota"
+}
+
+// UnmarshalJSON accepts the code as a string, a number or null, depending on the API flavor.
+func (e *APIError) UnmarshalJSON(data []byte) error {
+	type plain APIError
+	var v struct {
+		plain
+		Code  json.RawMessage `json:"code"`
+		Param json.RawMessage `json:"param"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = APIError(v.plain)
+	e.Code = rawString(v.Code)
+	e.Param = rawString(v.Param)
+	return nil
+}
+
+func rawString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if s := string(raw); s != "null" {
+		return s
+	}
+	return ""
+}
+
+// requestID 返回响应的请求 ID；Azure 使用 apim-request-id
+func requestID(h http.Header) string {
+	if id := h.Get("x-request-id"); len(id) > 0 {
+		return id
+	}
+	return h.Get("apim-request-id")
+}
@@ -0,0 +1,49 @@
+package gpt3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		target error
+	}{
+		{"context length", 400, `{"error":{"message":"This model's maximum context length is 4097 tokens.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`, ErrContextLengthExceeded},
+		{"rate limited", 429, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`, ErrRateLimited},
+		{"quota", 429, `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`, ErrQuotaExceeded},
+		{"auth", 401, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`, ErrInvalidAuth},
+		{"content filter", 400, `{"error":{"message":"The response was filtered","code":"content_filter","status":400}}`, ErrContentFiltered},
+		{"overloaded", 503, `upstream connect error`, ErrServerOverloaded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-request-id", "req_123")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := MakeGPT3Client(WithBaseURL(server.URL))
+			_, err := c.DoOnce(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}})
+			if !errors.Is(err, tt.target) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.target)
+			}
+			var apiErr APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("errors.As(%v) = false", err)
+			}
+			if apiErr.RequestID != "req_123" || apiErr.StatusCode != tt.status || string(apiErr.Body) != tt.body {
+				t.Errorf("api error = %+v", apiErr)
+			}
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -27,6 +29,9 @@ const (
 // DefaultRetry 默认重试次数
 const DefaultRetry = 1
 
+// DefaultEditsModel 默认的 edits 模型
+const DefaultEditsModel = "text-davinci-edit-001"
+
 type EmbeddingEngine string
 
 const (
@@ -65,17 +70,33 @@ type CompletionResponseInterface interface {
 	Role() string
 	Reset()
 	TotalTokens() int
+	// TokenUsage returns the full token usage of the response, including prompt, completion and cached tokens.
+	TokenUsage() Usage
+	// AllChoices returns every choice of the response, e.g. when the request set N > 1.
+	// Text, Role and CanContinue only look at the choice with index 0.
+	AllChoices() []Choice
 }
 
 // A Client is an API client to communicate with the OpenAI gpt-3 APIs
 type Client interface {
 	// Engines lists the currently available engines, and provides basic information about each
 	// option such as the owner and availability.
-	// Engines(ctx context.Context) (*EnginesResponse, error)
+	Engines(ctx context.Context) (*EnginesResponse, error)
 
 	// Engine retrieves an engine instance, providing basic information about the engine such
 	// as the owner and availability.
-	// Engine(ctx context.Context, engine EngineType) (*EngineObject, error)
+	Engine(ctx context.Context, engine EngineType) (*EngineObject, error)
+
+	// ListModels lists the currently available models, and provides basic information about each
+	// one such as the owner and permissions.
+	ListModels(ctx context.Context) (*ModelsResponse, error)
+
+	// GetModel retrieves a model instance, providing basic information about the model such
+	// as the owner and permissions.
+	GetModel(ctx context.Context, model string) (*Model, error)
+
+	// DeleteModel deletes a fine-tuned model.
+	DeleteModel(ctx context.Context, model string) (*DeleteModelResponse, error)
 
 	// Completion creates a completion with the default engine. This is the main endpoint of the API
 	// which auto-completes based on the given prompt.
@@ -91,25 +112,55 @@ type Client interface {
 	// CompletionStreamWithEngine is the same as CompletionStream except allows overriding the default engine on the client
 	CompletionStreamWithEngine(ctx context.Context, engine EngineType, request CompletionRequest, onData func(CompletionResponseInterface)) error
 
+	// OpenCompletionStreamWithEngine is the same as CompletionStreamWithEngine except it returns a Stream
+	// to receive the results from, instead of calling onData.
+	OpenCompletionStreamWithEngine(ctx context.Context, engine EngineType, request CompletionRequest) (*Stream, error)
+
 	ChatCompletion(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error)
 
 	// CompletionStream creates a completion with the default engine and streams the results through
 	// multiple calls to onData.
 	ChatCompletionStream(ctx context.Context, request ChatCompletionRequest, onData func(CompletionResponseInterface)) error
 
+	// OpenChatCompletionStream is the same as ChatCompletionStream except it returns a Stream
+	// to receive the results from, instead of calling onData.
+	OpenChatCompletionStream(ctx context.Context, request ChatCompletionRequest) (*Stream, error)
+
 	// Given a prompt and an instruction, the model will return an edited version of the prompt.
-	// Edits(ctx context.Context, request EditsRequest) (*EditsResponse, error)
+	Edits(ctx context.Context, request EditsRequest) (*EditsResponse, error)
 
 	// Search performs a semantic search over a list of documents with the default engine.
 	// Search(ctx context.Context, request SearchRequest) (*SearchResponse, error)
 
 	// SearchWithEngine performs a semantic search over a list of documents with the specified engine.
-	// SearchWithEngine(ctx context.Context, engine EngineType, request SearchRequest) (*SearchResponse, error)
+	SearchWithEngine(ctx context.Context, engine EngineType, request SearchRequest) (*SearchResponse, error)
 
 	// Returns an embedding using the provided request.
-	// Embeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error)
+	Embeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error)
 
 	CreateImage(ctx context.Context, request CreateImageReq) (*CreateImageResp, error)
+
+	// EditImage creates edited or extended images given an original image, an optional mask and a prompt.
+	EditImage(ctx context.Context, request EditImageReq) (*CreateImageResp, error)
+
+	// CreateImageVariation creates variations of a given image.
+	CreateImageVariation(ctx context.Context, request ImageVariationReq) (*CreateImageResp, error)
+
+	// Moderations classifies whether the inputs violate the usage policies.
+	Moderations(ctx context.Context, request ModerationRequest) (*ModerationResponse, error)
+
+	// CreateTranscription transcribes audio into the input language.
+	CreateTranscription(ctx context.Context, request AudioRequest) (*AudioResponse, error)
+
+	// CreateTranslation translates audio into English.
+	CreateTranslation(ctx context.Context, request AudioRequest) (*AudioResponse, error)
+
+	// CreateSpeech generates audio from the input text, the caller must close the returned body.
+	CreateSpeech(ctx context.Context, request SpeechRequest) (io.ReadCloser, error)
+
+	// RateLimit returns the rate limit state reported by the latest response that carried
+	// x-ratelimit-* headers.
+	RateLimit() RateLimitState
 }
 
 type client struct {
@@ -117,6 +168,17 @@ type client struct {
 	userAgent  string
 	httpClient *http.Client
 	idOrg      string
+	limiter    *rateLimiter
+	middleware []Middleware
+	doer       Doer
+	logger     *slog.Logger
+	redactor   Redactor
+	telemetry  *telemetry
+	pricing    Pricing
+	budget     *Budget
+
+	rateMu    sync.RWMutex
+	rateLimit RateLimitState
 
 	gpt3 *GPT3client
 }
@@ -132,62 +194,65 @@ func NewClient(gpt3 *GPT3client, options ...ClientOption) Client {
 		baseURL:    defaultBaseURL,
 		httpClient: httpClient,
 		idOrg:      "",
+		pricing:    DefaultPricing,
 
 		gpt3: gpt3,
 	}
 	for _, o := range options {
 		o(c)
 	}
+	var doer Doer = DoerFunc(c.performRequest)
+	if c.logger != nil {
+		// 日志在最内层，记录实际发出的请求
+		doer = logRequests(c.logger, c.redactor)(doer)
+	}
+	if c.telemetry != nil {
+		doer = c.telemetry.middleware(doer)
+	}
+	c.doer = chain(c.middleware, doer)
 	return c
 }
 
 func (c *client) Engines(ctx context.Context) (*EnginesResponse, error) {
-	req, err := c.newRequest(ctx, "GET", "/engines", nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.performRequest(req)
+	req, err := c.newRequest(ctx, OperationEngines, "GET", "/engines", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	output := new(EnginesResponse)
-	if err := getResponseObject(resp, output); err != nil {
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
 }
 
 func (c *client) Engine(ctx context.Context, engine EngineType) (*EngineObject, error) {
-	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/engines/%s", engine), nil)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.performRequest(req)
+	req, err := c.newRequest(ctx, OperationEngine, "GET", fmt.Sprintf("/engines/%s", engine), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	output := new(EngineObject)
-	if err := getResponseObject(resp, output); err != nil {
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
 }
 
-func (c *client) CompletionWithEngine(ctx context.Context, engine EngineType, request CompletionRequest) (*CompletionResponse, error) {
+func (c *client) CompletionWithEngine(ctx context.Context, engine EngineType, request CompletionRequest) (output *CompletionResponse, err error) {
 	request.Stream = false
-	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/engines/%s/completions", engine), request)
-	if err != nil {
+	ctx, span := c.telemetry.start(ctx, genAITextCompletion, string(engine), request)
+	defer func() { span.end(output, err) }()
+	if err := c.admit(ctx, string(engine), estimateCompletionTokens(request)); err != nil {
 		return nil, err
 	}
-	resp, err := c.performRequest(req)
+	req, err := c.newRequest(ctx, OperationCompletions, "POST", fmt.Sprintf("/engines/%s/completions", engine), request)
 	if err != nil {
 		return nil, err
 	}
 
-	output := new(CompletionResponse)
-	if err := getResponseObject(resp, output); err != nil {
+	output = new(CompletionResponse)
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
@@ -198,46 +263,132 @@ func (c *client) CompletionStreamWithEngine(
 	engine EngineType,
 	request CompletionRequest,
 	onData func(CompletionResponseInterface),
-) error {
+) (err error) {
 	request.Stream = true
-	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/engines/%s/completions", engine), request)
+	ctx, span := c.telemetry.start(ctx, genAITextCompletion, string(engine), request)
+	defer func() {
+		if err != nil {
+			span.end(nil, err)
+		}
+	}()
+	if err := c.admit(ctx, string(engine), estimateCompletionTokens(request)); err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, OperationCompletions, "POST", fmt.Sprintf("/engines/%s/completions", engine), request)
 	if err != nil {
 		return err
 	}
-	return c.sendAndOnData(req, new(CompletionResponse), onData)
+	return c.sendAndOnData(req, span, estimateCompletionTokens(request), new(CompletionResponse), onData)
 }
 
-func (c *client) sendAndOnData(req *http.Request, output CompletionResponseInterface, onData func(CompletionResponseInterface)) error {
-	var (
-		err  error
-		resp *http.Response
-	)
+// OpenCompletionStreamWithEngine creates a completion with the specified engine and returns a Stream
+// of *CompletionResponse chunks. The caller must Close the stream.
+func (c *client) OpenCompletionStreamWithEngine(ctx context.Context, engine EngineType, request CompletionRequest) (stream *Stream, err error) {
+	request.Stream = true
+	ctx, span := c.telemetry.start(ctx, genAITextCompletion, string(engine), request)
+	defer func() {
+		if err != nil {
+			span.end(nil, err)
+		}
+	}()
+	if err := c.admit(ctx, string(engine), estimateCompletionTokens(request)); err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, OperationCompletions, "POST", fmt.Sprintf("/engines/%s/completions", engine), request)
+	if err != nil {
+		return nil, err
+	}
+	return c.openStream(req, span, estimateCompletionTokens(request), func() CompletionResponseInterface {
+		return new(CompletionResponse)
+	})
+}
 
-	var handle = func() error {
-		resp, err = c.performRequest(req)
+func (c *client) sendAndOnData(req *Request, span *operationSpan, estimate tokenEstimate, output CompletionResponseInterface, onData func(CompletionResponseInterface)) error {
+	stream, err := c.openStream(req, span, estimate, func() CompletionResponseInterface {
+		output.Reset()
+		return output
+	})
+	if err != nil {
 		return err
 	}
+	return stream.forEach(onData)
+}
 
-	// 加入重试机制
-	if err = retry(handle, c.gpt3.maxretry, time.Second/2); err != nil {
-		request, _ := httputil.DumpRequest(req, true)
-		return errors.Wrapf(err, "重试请求失败:url=%v,req=%v", req.URL.String(), string(request))
+// openStream 发送流式请求并返回 Stream，span 在流结束时结束。
+// 设置了 Budget 时，流结束仍未收到用量(未开启 WithStreamUsage)则按 estimate 的提示 token 数及已生成内容的 token 数计入预算
+func (c *client) openStream(req *Request, span *operationSpan, estimate tokenEstimate, newChunk func() CompletionResponseInterface) (*Stream, error) {
+	body, err := c.sendStream(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, model := req.HTTP.Context(), requestModel(req)
+	stream := newStream(ctx, body, newChunk)
+	stream.span = span
+	stream.onUsage = func(u Usage) {
+		c.onUsage(ctx, model, u)
+	}
+	if c.budget != nil {
+		stream.prompt = estimate.prompt
+		stream.onEstimate = func(u Usage) {
+			c.charge(ctx, model, u)
+		}
 	}
-	return StreamOnData(resp.Body, output, onData)
+	return stream, nil
 }
 
-func (c *client) Edits(ctx context.Context, request EditsRequest) (*EditsResponse, error) {
-	req, err := c.newRequest(ctx, "POST", "/edits", request)
+// admit 发送请求前检查租户的预算，并等待客户端限流
+func (c *client) admit(ctx context.Context, model string, estimate tokenEstimate) error {
+	if c.budget != nil {
+		price, err := c.budget.price(c.pricing, model)
+		if err != nil {
+			return err
+		}
+		cost := price.Cost(Usage{PromptTokens: estimate.prompt, CompletionTokens: estimate.completion})
+		if err := c.budget.check(TenantFromContext(ctx), cost); err != nil {
+			return err
+		}
+	}
+	return c.limiter.wait(ctx, estimate.total())
+}
+
+// onUsage 记录一次请求的用量：按价格表计算花费，累加到 ctx 中的 UsageRecorder 并计入租户的预算
+func (c *client) onUsage(ctx context.Context, model string, u Usage) {
+	if u.IsZero() {
+		return
+	}
+	cost, _ := c.pricing.Cost(model, u)
+	usageRecorderFrom(ctx).add(u, cost)
+	c.charge(ctx, model, u)
+}
+
+// charge 按用量计入租户的预算；价格表中没有的模型按 FallbackPrice 计算
+func (c *client) charge(ctx context.Context, model string, u Usage) {
+	if c.budget == nil {
+		return
+	}
+	if price, err := c.budget.price(c.pricing, model); err == nil {
+		c.budget.Charge(TenantFromContext(ctx), price.Cost(u))
+	}
+}
+
+// sendStream 发送流式请求，返回响应的 body
+func (c *client) sendStream(req *Request) (io.ReadCloser, error) {
+	req.Stream = true
+	resp, err := c.doRequest(req, nil)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "请求失败:operation=%v", req.Operation)
 	}
-	resp, err := c.performRequest(req)
+	return resp.HTTP.Body, nil
+}
+
+func (c *client) Edits(ctx context.Context, request EditsRequest) (*EditsResponse, error) {
+	req, err := c.newRequest(ctx, OperationEdits, "POST", "/edits", request)
 	if err != nil {
 		return nil, err
 	}
 
 	output := new(EditsResponse)
-	if err := getResponseObject(resp, output); err != nil {
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
@@ -248,16 +399,13 @@ func (c *client) Edits(ctx context.Context, request EditsRequest) (*EditsRespons
 // }
 
 func (c *client) SearchWithEngine(ctx context.Context, engine EngineType, request SearchRequest) (*SearchResponse, error) {
-	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/engines/%s/search", engine), request)
-	if err != nil {
-		return nil, err
-	}
-	resp, err := c.performRequest(req)
+	req, err := c.newRequest(ctx, OperationSearch, "POST", fmt.Sprintf("/engines/%s/search", engine), request)
 	if err != nil {
 		return nil, err
 	}
+
 	output := new(SearchResponse)
-	if err := getResponseObject(resp, output); err != nil {
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
@@ -266,34 +414,94 @@ func (c *client) SearchWithEngine(ctx context.Context, engine EngineType, reques
 // Embeddings creates text embeddings for a supplied slice of inputs with a provided model.
 //
 // See: https://beta.openai.com/docs/api-reference/embeddings
-func (c *client) Embeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
-	req, err := c.newRequest(ctx, "POST", "/embeddings", request)
-	if err != nil {
+func (c *client) Embeddings(ctx context.Context, request EmbeddingsRequest) (output *EmbeddingsResponse, err error) {
+	ctx, span := c.telemetry.start(ctx, genAIEmbeddings, request.Model, request)
+	defer func() { span.end(output, err) }()
+	if err := c.admit(ctx, request.Model, estimateEmbeddingsTokens(request)); err != nil {
 		return nil, err
 	}
-	resp, err := c.performRequest(req)
+	req, err := c.newRequest(ctx, OperationEmbeddings, "POST", "/embeddings", request)
 	if err != nil {
 		return nil, err
 	}
 
-	output := EmbeddingsResponse{}
-	if err := getResponseObject(resp, &output); err != nil {
+	output = new(EmbeddingsResponse)
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
-	return &output, nil
+	return output, nil
 }
 
-func (c *client) performRequest(req *http.Request) (*http.Response, error) {
-	resp, err := c.httpClient.Do(req)
+// doRequest 发送请求，失败时按重试策略重试；output 不为 nil 时把响应解码到 output。
+// 每次尝试都经过中间件，并通过 GetBody 重新生成请求体，保证重发的内容与第一次相同。
+func (c *client) doRequest(req *Request, output interface{}) (*Response, error) {
+	var (
+		resp    *Response
+		attempt int
+	)
+	err := retry(req.HTTP.Context(), c.gpt3.retryPolicy(), func() error {
+		attempt++
+		r := *req
+		r.Attempt = attempt
+		r.output = output
+		if attempt > 1 && req.HTTP.GetBody != nil {
+			body, err := req.HTTP.GetBody()
+			if err != nil {
+				return err
+			}
+			r.HTTP = req.HTTP.Clone(req.HTTP.Context())
+			r.HTTP.Body = body
+		}
+
+		var err error
+		resp, err = c.doer.Do(&r)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := checkForSuccess(resp); err != nil {
+	return resp, nil
+}
+
+// performRequest 中间件链最内层的 Doer：发送 HTTP 请求，检查状态并解码响应
+func (c *client) performRequest(req *Request) (*Response, error) {
+	httpResp, err := c.httpClient.Do(req.HTTP)
+	if err != nil {
 		return nil, err
 	}
+	c.observeRateLimit(httpResp.Header)
+	resp := &Response{HTTP: httpResp}
+	if err := checkForSuccess(httpResp); err != nil {
+		return resp, err
+	}
+	if req.output != nil {
+		if err := getResponseObject(httpResp, req.output); err != nil {
+			return resp, err
+		}
+		resp.Result = req.output
+		c.onUsage(req.HTTP.Context(), requestModel(req), responseUsage(resp.Result))
+	}
 	return resp, nil
 }
 
+// observeRateLimit 记录响应头中的限流状态
+func (c *client) observeRateLimit(h http.Header) {
+	state := parseRateLimitState(h)
+	if state.UpdatedAt.IsZero() {
+		return
+	}
+	c.rateMu.Lock()
+	c.rateLimit = state
+	c.rateMu.Unlock()
+	c.limiter.observe(state)
+}
+
+func (c *client) RateLimit() RateLimitState {
+	c.rateMu.RLock()
+	defer c.rateMu.RUnlock()
+	return c.rateLimit
+}
+
 // returns an error if this response includes an error.
 func checkForSuccess(resp *http.Response) error {
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
@@ -307,21 +515,31 @@ func checkForSuccess(resp *http.Response) error {
 	}
 
 	var result APIErrorResponse
-	if err := json.Unmarshal(data, &result); err != nil {
+	if err := json.Unmarshal(data, &result); err != nil || len(result.Error.Message)+len(result.Error.Type)+len(result.Error.Code) == 0 {
 		// if we can't decode the json error then create an unexpected error
-		apiError := APIError{
-			StatusCode: resp.StatusCode,
-			Type:       "Unexpected",
-			Message:    string(data),
+		result.Error = APIError{
+			Type:    "Unexpected",
+			Message: string(data),
 		}
-		return apiError
 	}
 	result.Error.StatusCode = resp.StatusCode
+	result.Error.RequestID = requestID(resp.Header)
+	result.Error.RateLimit = parseRateLimitState(resp.Header)
+	result.Error.Header = resp.Header
+	result.Error.Body = data
 	return result.Error
 }
 
+// responseDecoder 自行解析响应体的输出类型，如可能返回纯文本的 AudioResponse
+type responseDecoder interface {
+	decodeResponse(rsp *http.Response) error
+}
+
 func getResponseObject(rsp *http.Response, v interface{}) error {
 	defer rsp.Body.Close()
+	if d, ok := v.(responseDecoder); ok {
+		return d.decodeResponse(rsp)
+	}
 	if err := json.NewDecoder(rsp.Body).Decode(v); err != nil {
 		return fmt.Errorf("invalid json response: %w", err)
 	}
@@ -339,8 +557,36 @@ func jsonBodyReader(body interface{}) (io.Reader, error) {
 	return bytes.NewBuffer(raw), nil
 }
 
-func (c *client) newRequest(ctx context.Context, method, path string, payload interface{}) (*http.Request, error) {
-	bodyReader, err := jsonBodyReader(payload)
+// multipartPayload 以 multipart/form-data 发送的请求内容，如上传文件的请求
+type multipartPayload interface {
+	writeMultipart(w *multipart.Writer) error
+}
+
+// multipartBodyReader 把 payload 写入内存中的 multipart 请求体，以便重试时重新发送
+func multipartBodyReader(payload multipartPayload) (io.Reader, string, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	if err := payload.writeMultipart(w); err != nil {
+		return nil, "", fmt.Errorf("failed encoding multipart: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed encoding multipart: %w", err)
+	}
+	return body, w.FormDataContentType(), nil
+}
+
+// newRequest 创建请求；payload 实现 multipartPayload 时以 multipart/form-data 发送，否则以 JSON 发送
+func (c *client) newRequest(ctx context.Context, operation, method, path string, payload interface{}) (*Request, error) {
+	var (
+		bodyReader  io.Reader
+		contentType = "application/json"
+		err         error
+	)
+	if p, ok := payload.(multipartPayload); ok {
+		bodyReader, contentType, err = multipartBodyReader(p)
+	} else {
+		bodyReader, err = jsonBodyReader(payload)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -358,11 +604,15 @@ func (c *client) newRequest(ctx context.Context, method, path string, payload in
 	if len(c.idOrg) > 0 {
 		req.Header.Set("OpenAI-Organization", c.idOrg)
 	}
-	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("Content-type", contentType)
 	if len(c.gpt3.authtoken) > 0 {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.gpt3.authtoken))
 	} else if len(c.gpt3.apikey) > 0 {
 		req.Header.Set("api-key", c.gpt3.apikey)
 	}
-	return req, nil
+	return &Request{
+		Operation: operation,
+		Payload:   payload,
+		HTTP:      req,
+	}, nil
 }
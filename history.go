@@ -0,0 +1,183 @@
+package gpt3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HistoryStore 保存对话的历史消息，按对话 id 区分。实现需要可以并发使用。
+type HistoryStore interface {
+	// Load 按顺序返回对话的所有消息；对话不存在时返回空
+	Load(ctx context.Context, id string) ([]ChatCompletionMessage, error)
+	// Append 在对话末尾追加消息
+	Append(ctx context.Context, id string, msgs ...ChatCompletionMessage) error
+	// Clear 删除对话的所有消息
+	Clear(ctx context.Context, id string) error
+}
+
+// MemoryHistoryStore 保存在内存中的 HistoryStore，进程重启后丢失
+type MemoryHistoryStore struct {
+	mu       sync.Mutex
+	sessions map[string][]ChatCompletionMessage
+}
+
+// NewMemoryHistoryStore 创建 MemoryHistoryStore
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		sessions: map[string][]ChatCompletionMessage{},
+	}
+}
+
+func (s *MemoryHistoryStore) Load(ctx context.Context, id string) ([]ChatCompletionMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatCompletionMessage(nil), s.sessions[id]...), nil
+}
+
+func (s *MemoryHistoryStore) Append(ctx context.Context, id string, msgs ...ChatCompletionMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = append(s.sessions[id], msgs...)
+	return nil
+}
+
+func (s *MemoryHistoryStore) Clear(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// FileHistoryStore 保存在目录中的 HistoryStore，每个对话一个 JSONL 文件，每行一条消息。
+// 只追加写入，进程在写入中途退出时，Load 忽略最后不完整的一行，下次 Append 时删除该行。
+// 同一目录只能由一个 FileHistoryStore 使用，多个进程同时写入同一个对话时消息可能交错。
+type FileHistoryStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileHistoryStore 创建使用 dir 目录的 FileHistoryStore，目录不存在时创建
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "创建历史消息目录失败")
+	}
+	return &FileHistoryStore{dir: dir}, nil
+}
+
+// path 对话的文件路径；id 经过转义，不会指向目录之外
+func (s *FileHistoryStore) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+".jsonl")
+}
+
+func (s *FileHistoryStore) Load(ctx context.Context, id string) ([]ChatCompletionMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "读取历史消息失败")
+	}
+	defer f.Close()
+
+	var msgs []ChatCompletionMessage
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// 没有换行结尾的是写入中断的一行
+			return msgs, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "读取历史消息失败")
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		var msg ChatCompletionMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, errors.Wrapf(err, "解析历史消息失败:id=%v, line=%d", id, line)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func (s *FileHistoryStore) Append(ctx context.Context, id string, msgs ...ChatCompletionMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return errors.Wrap(err, "序列化历史消息失败")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path(id), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "写入历史消息失败")
+	}
+	// 去掉写入中断的最后一行，否则新消息会接在它后面，之后的 Load 都会失败
+	end, err := trimTornLine(f)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "写入历史消息失败")
+	}
+	// 一次写入所有消息，减少中途退出时只写入部分消息的可能
+	if _, err := f.WriteAt(buf.Bytes(), end); err != nil {
+		f.Close()
+		return errors.Wrap(err, "写入历史消息失败")
+	}
+	return errors.Wrap(f.Close(), "写入历史消息失败")
+}
+
+// trimTornLine 把文件截断到最后一个换行符之后，返回截断后的长度
+func trimTornLine(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			if end == size {
+				return size, nil
+			}
+			return end, f.Truncate(end)
+		}
+		end = start
+	}
+	// 没有完整的一行
+	return 0, f.Truncate(0)
+}
+
+func (s *FileHistoryStore) Clear(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "删除历史消息失败")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// historyBucket BoltHistoryStore 的根 bucket，每个对话是其中的一个子 bucket
+var historyBucket = []byte("gpt3.history")
+
+// BoltHistoryStore 保存在单个 bbolt 数据库文件中的 HistoryStore，纯 Go 实现，不依赖 cgo 及 SQLite。
+// 每次 Append 是一个事务，进程中途退出时不会留下不完整的消息。
+// 数据库文件同时只能由一个进程打开，其他进程打开时等待1秒后返回错误。
+type BoltHistoryStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltHistoryStore 打开或创建 path 处的数据库文件；不再使用时需要 Close
+func OpenBoltHistoryStore(path string) (*BoltHistoryStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "打开历史消息数据库失败")
+	}
+	return &BoltHistoryStore{db: db}, nil
+}
+
+// Close 关闭数据库文件
+func (s *BoltHistoryStore) Close() error {
+	return s.db.Close()
+}
+
+// conversationBucket 对话的子 bucket 名；加前缀使空 id 也是合法的名字
+func conversationBucket(id string) []byte {
+	return append([]byte("c:"), id...)
+}
+
+func (s *BoltHistoryStore) Load(ctx context.Context, id string) ([]ChatCompletionMessage, error) {
+	var msgs []ChatCompletionMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket)
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket(conversationBucket(id))
+		if b == nil {
+			return nil
+		}
+		// key 为递增的序号，按 key 遍历即为追加的顺序
+		return b.ForEach(func(k, v []byte) error {
+			var msg ChatCompletionMessage
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return errors.Wrapf(err, "解析历史消息失败:id=%v, seq=%d", id, binary.BigEndian.Uint64(k))
+			}
+			msgs = append(msgs, msg)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "读取历史消息失败")
+	}
+	return msgs, nil
+}
+
+func (s *BoltHistoryStore) Append(ctx context.Context, id string, msgs ...ChatCompletionMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists(conversationBucket(id))
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "写入历史消息失败")
+}
+
+func (s *BoltHistoryStore) Clear(ctx context.Context, id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket)
+		if root == nil {
+			return nil
+		}
+		if err := root.DeleteBucket(conversationBucket(id)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+	return errors.Wrap(err, "删除历史消息失败")
+}
@@ -0,0 +1,97 @@
+package gpt3
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHistoryStoreTornLine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := ChatCompletionMessage{Role: ChatMessageRoleUser, Content: "a"}
+	if err := store.Append(ctx, "s", first); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入中断，留下没有换行的半行
+	f, err := os.OpenFile(filepath.Join(dir, "s.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"role":"assis`)
+	f.Close()
+
+	second := ChatCompletionMessage{Role: ChatMessageRoleAssistant, Content: "b"}
+	if err := store.Append(ctx, "s", second); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := store.Load(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Content != "a" || msgs[1].Content != "b" {
+		t.Errorf("Load = %+v", msgs)
+	}
+
+	// 整个文件只有半行
+	os.WriteFile(filepath.Join(dir, "t.jsonl"), []byte(`{"role":`), 0o644)
+	if err := store.Append(ctx, "t", first); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := store.Load(ctx, "t"); err != nil || len(msgs) != 1 {
+		t.Errorf("Load = %+v, %v", msgs, err)
+	}
+}
+
+func TestBoltHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := OpenBoltHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := ToolCall{ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "weather", Arguments: `{"city":"北京"}`}}
+	if err := store.Append(ctx, "s", ChatCompletionMessage{Role: ChatMessageRoleUser, Content: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(ctx, "s", ChatCompletionMessage{Role: ChatMessageRoleAssistant, ToolCalls: []ToolCall{call}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(ctx, "", ChatCompletionMessage{Role: ChatMessageRoleUser, Content: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 重新打开后按追加的顺序读出
+	store, err = OpenBoltHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	msgs, err := store.Load(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Content != "a" || len(msgs[1].ToolCalls) != 1 || msgs[1].ToolCalls[0].Function.Arguments != call.Function.Arguments {
+		t.Errorf("Load = %+v", msgs)
+	}
+
+	if err := store.Clear(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, err := store.Load(ctx, "s"); err != nil || len(msgs) != 0 {
+		t.Errorf("Load after Clear = %+v, %v", msgs, err)
+	}
+	if msgs, err := store.Load(ctx, ""); err != nil || len(msgs) != 1 {
+		t.Errorf("Load other = %+v, %v", msgs, err)
+	}
+	if err := store.Clear(ctx, "missing"); err != nil {
+		t.Error(err)
+	}
+}
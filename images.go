@@ -1,6 +1,16 @@
 package gpt3
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
 
 type ImageSizeType string
 
@@ -28,19 +38,143 @@ type CreateImageResp struct {
 	Created int64  `json:"created"`
 }
 
-func (c *client) CreateImage(ctx context.Context, request CreateImageReq) (*CreateImageResp, error) {
-	req, err := c.newRequest(ctx, "POST", "/images/generations", request)
+func (c *client) CreateImage(ctx context.Context, request CreateImageReq) (output *CreateImageResp, err error) {
+	ctx, span := c.telemetry.start(ctx, genAIImageGeneration, "", request)
+	defer func() { span.end(output, err) }()
+	req, err := c.newRequest(ctx, OperationCreateImage, "POST", "/images/generations", request)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.performRequest(req)
+
+	output = new(CreateImageResp)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// maxImageFileSize 上传的图片最大4MB
+const maxImageFileSize = 4 << 20
+
+type EditImageReq struct {
+	// The image to edit. Must be a valid square PNG file, less than 4MB.
+	// If mask is not provided, image must have transparency, which will be used as the mask.
+	Image io.Reader `json:"-"`
+	// An additional image whose fully transparent areas indicate where image should be edited.
+	// Must be a valid PNG file, less than 4MB, and have the same dimensions as image.
+	Mask io.Reader `json:"-"`
+	// A text description of the desired image(s). The maximum length is 1000 characters.
+	Prompt string `json:"prompt"`
+	// The number of images to generate. Must be between 1 and 10.
+	N int `json:"n"`
+	// The size of the generated images. Must be one of 256x256, 512x512, or 1024x1024.
+	Size ImageSizeType `json:"size"`
+}
+
+func (r EditImageReq) writeMultipart(w *multipart.Writer) error {
+	if err := writeImageFile(w, "image", r.Image); err != nil {
+		return err
+	}
+	if r.Mask != nil {
+		if err := writeImageFile(w, "mask", r.Mask); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteField("prompt", r.Prompt); err != nil {
+		return err
+	}
+	return writeImageFields(w, r.N, r.Size)
+}
+
+type ImageVariationReq struct {
+	// The image to use as the basis for the variation(s). Must be a valid square PNG file, less than 4MB.
+	Image io.Reader `json:"-"`
+	// The number of images to generate. Must be between 1 and 10.
+	N int `json:"n"`
+	// The size of the generated images. Must be one of 256x256, 512x512, or 1024x1024.
+	Size ImageSizeType `json:"size"`
+}
+
+func (r ImageVariationReq) writeMultipart(w *multipart.Writer) error {
+	if err := writeImageFile(w, "image", r.Image); err != nil {
+		return err
+	}
+	return writeImageFields(w, r.N, r.Size)
+}
+
+func writeImageFile(w *multipart.Writer, field string, r io.Reader) error {
+	fw, err := w.CreateFormFile(field, field+".png")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func writeImageFields(w *multipart.Writer, n int, size ImageSizeType) error {
+	if n > 0 {
+		if err := w.WriteField("n", strconv.Itoa(n)); err != nil {
+			return err
+		}
+	}
+	if len(size) > 0 {
+		return w.WriteField("size", string(size))
+	}
+	return nil
+}
+
+func (c *client) EditImage(ctx context.Context, request EditImageReq) (output *CreateImageResp, err error) {
+	return c.uploadImage(ctx, OperationEditImage, "/images/edits", request)
+}
+
+func (c *client) CreateImageVariation(ctx context.Context, request ImageVariationReq) (output *CreateImageResp, err error) {
+	return c.uploadImage(ctx, OperationImageVariation, "/images/variations", request)
+}
+
+func (c *client) uploadImage(ctx context.Context, operation, path string, request multipartPayload) (output *CreateImageResp, err error) {
+	ctx, span := c.telemetry.start(ctx, genAIImageGeneration, "", request)
+	defer func() { span.end(output, err) }()
+	req, err := c.newRequest(ctx, operation, "POST", path, request)
 	if err != nil {
 		return nil, err
 	}
 
-	output := new(CreateImageResp)
-	if err := getResponseObject(resp, output); err != nil {
+	output = new(CreateImageResp)
+	if _, err := c.doRequest(req, output); err != nil {
 		return nil, err
 	}
 	return output, nil
 }
+
+// readPNG 读取上传的图片并检查是否为不超过4MB的 PNG 文件，返回内容及尺寸
+func readPNG(r io.Reader, name string) ([]byte, image.Config, error) {
+	if r == nil {
+		return nil, image.Config{}, errors.Errorf("缺少%v图片。", name)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxImageFileSize+1))
+	if err != nil {
+		return nil, image.Config{}, errors.Wrapf(err, "读取%v图片失败", name)
+	}
+	if len(data) > maxImageFileSize {
+		return nil, image.Config{}, errors.Errorf("%v图片不能超过4MB。", name)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, image.Config{}, errors.Wrapf(err, "%v图片需要是 PNG 格式", name)
+	}
+	return data, config, nil
+}
+
+// checkImageOptions 检查生成数量及尺寸，与 CreateImage 相同，数量不大于10，未指定时为1
+func checkImageOptions(n *int, size ImageSizeType) error {
+	if *n > 10 {
+		return errors.New("不要太贪心，先试试取一幅图~")
+	} else if *n <= 0 {
+		*n = 1
+	}
+	switch size {
+	case "", IST256, IST512, IST1024:
+		return nil
+	}
+	return errors.Errorf("不支持的图片尺寸: %v", size)
+}
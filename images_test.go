@@ -0,0 +1,83 @@
+package gpt3
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEditImage(t *testing.T) {
+	square := testPNG(t, 4, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f, _, err := r.FormFile("image")
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		if !bytes.Equal(data, square) || r.FormValue("n") != "1" || r.FormValue("size") != string(IST256) {
+			t.Errorf("%v: form = %v", r.URL.Path, r.MultipartForm.Value)
+		}
+		_, _, maskErr := r.FormFile("mask")
+		switch r.URL.Path {
+		case "/images/edits":
+			if r.FormValue("prompt") != "a hat" || maskErr != nil {
+				t.Errorf("edit prompt = %q, mask %v", r.FormValue("prompt"), maskErr)
+			}
+		case "/images/variations":
+			if maskErr == nil {
+				t.Error("variation sent a mask")
+			}
+		}
+		io.WriteString(w, `{"created":1,"data":[{"url":"https://example.com/a.png"}]}`)
+	}))
+	defer server.Close()
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	ctx := context.Background()
+
+	resp, err := c.EditImage(ctx, EditImageReq{
+		Image:  bytes.NewReader(square),
+		Mask:   bytes.NewReader(square),
+		Prompt: "a hat",
+		Size:   IST256,
+	})
+	if err != nil || len(resp.Data) != 1 {
+		t.Fatalf("EditImage = %+v, %v", resp, err)
+	}
+	if _, err := c.CreateImageVariation(ctx, ImageVariationReq{Image: bytes.NewReader(square), Size: IST256}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, req := range map[string]EditImageReq{
+		"not png":    {Image: strings.NewReader("GIF89a"), Prompt: "a hat"},
+		"not square": {Image: bytes.NewReader(testPNG(t, 4, 2)), Prompt: "a hat"},
+		"mask size":  {Image: bytes.NewReader(square), Mask: bytes.NewReader(testPNG(t, 2, 2)), Prompt: "a hat"},
+		"too many":   {Image: bytes.NewReader(square), Prompt: "a hat", N: 11},
+		"bad size":   {Image: bytes.NewReader(square), Prompt: "a hat", Size: "100x100"},
+		"no prompt":  {Image: bytes.NewReader(square)},
+	} {
+		if _, err := c.EditImage(ctx, req); err == nil {
+			t.Errorf("%v: accepted", name)
+		}
+	}
+}
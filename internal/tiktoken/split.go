@@ -0,0 +1,183 @@
+package tiktoken
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitCL100k splits text the same way as the cl100k_base pattern
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// The pattern needs a lookahead, which regexp does not support, so it is matched by hand.
+func splitCL100k(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		n := matchCL100k(text)
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+func matchCL100k(s string) int {
+	r0, n0 := utf8.DecodeRuneInString(s)
+
+	// (?i:'s|'t|'re|'ve|'m|'ll|'d)
+	if r0 == '\'' {
+		if n := matchContraction(s[1:], true); n > 0 {
+			return 1 + n
+		}
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	if isLetter(r0) {
+		return n0 + countPrefix(s[n0:], -1, isLetter)
+	}
+	if r0 != '\r' && r0 != '\n' && !isNumber(r0) {
+		if r1, n1 := utf8.DecodeRuneInString(s[n0:]); isLetter(r1) {
+			return n0 + n1 + countPrefix(s[n0+n1:], -1, isLetter)
+		}
+	}
+
+	// \p{N}{1,3}
+	if isNumber(r0) {
+		return n0 + countPrefix(s[n0:], 2, isNumber)
+	}
+
+	// ?[^\s\p{L}\p{N}]+[\r\n]*
+	i := 0
+	if r0 == ' ' {
+		i = 1
+	}
+	if n := countPrefix(s[i:], -1, isPunct); n > 0 {
+		i += n
+		return i + countPrefix(s[i:], -1, isNewline)
+	}
+
+	return matchWhitespace(s, true)
+}
+
+// splitR50k splits text the same way as the r50k_base pattern
+//
+//	's|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+
+func splitR50k(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		n := matchR50k(text)
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+func matchR50k(s string) int {
+	r0, _ := utf8.DecodeRuneInString(s)
+
+	// 's|'t|'re|'ve|'m|'ll|'d
+	if r0 == '\'' {
+		if n := matchContraction(s[1:], false); n > 0 {
+			return 1 + n
+		}
+	}
+
+	// ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+
+	i := 0
+	if r0 == ' ' {
+		i = 1
+	}
+	for _, class := range []func(rune) bool{isLetter, isNumber, isPunct} {
+		if n := countPrefix(s[i:], -1, class); n > 0 {
+			return i + n
+		}
+	}
+
+	return matchWhitespace(s, false)
+}
+
+// matchWhitespace matches \s*[\r\n]+|\s+(?!\S)|\s+ at the start of s, or only \s+(?!\S)|\s+
+// when newlines is false.
+func matchWhitespace(s string, newlines bool) int {
+	// 空白串的长度、最后一个换行之后的位置及最后一个字符的长度
+	n, afterNewline, last, runes := 0, 0, 0, 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		n += size
+		last = size
+		runes++
+		if isNewline(r) {
+			afterNewline = n
+		}
+	}
+
+	switch {
+	case newlines && afterNewline > 0:
+		// \s*[\r\n]+
+		return afterNewline
+	case n == len(s) || runes < 2:
+		// \s+(?!\S) 到达结尾，或者只能匹配 \s+
+		return n
+	default:
+		// \s+(?!\S) 回退一个字符，把最后的空白留给下一个片段
+		return n - last
+	}
+}
+
+// matchContraction matches s|t|re|ve|m|ll|d at the start of s, case-insensitively when fold is set,
+// and returns the length of the match.
+func matchContraction(s string, fold bool) int {
+	for _, suffix := range []string{"s", "t", "re", "ve", "m", "ll", "d"} {
+		if len(s) < len(suffix) {
+			continue
+		}
+		prefix := s[:len(suffix)]
+		if prefix == suffix || (fold && asciiLower(prefix) == suffix) {
+			return len(suffix)
+		}
+	}
+	return 0
+}
+
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// countPrefix returns the length in bytes of the longest prefix of s, at most max runes when
+// max >= 0, whose runes all satisfy class.
+func countPrefix(s string, max int, class func(rune) bool) int {
+	n := 0
+	for count := 0; n < len(s) && (max < 0 || count < max); count++ {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if !class(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+func isLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func isNumber(r rune) bool {
+	return unicode.IsNumber(r)
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isPunct reports whether r matches [^\s\p{L}\p{N}]
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
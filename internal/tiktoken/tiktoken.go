@@ -0,0 +1,238 @@
+// Package tiktoken is an offline implementation of the byte pair encodings used by the OpenAI
+// models. The merge ranks are embedded in the binary, so no network access is needed.
+package tiktoken
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// Chat message framing, see
+// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+const (
+	// TokensPerMessage every message follows <|start|>{role/name}\n{content}<|end|>\n
+	TokensPerMessage = 3
+	// TokensPerName if there's a name, the role is omitted
+	TokensPerName = 1
+	// TokensReplyPriming every reply is primed with <|start|>assistant<|message|>
+	TokensReplyPriming = 3
+)
+
+var (
+	//go:embed cl100k_base.tiktoken.gz
+	cl100kBaseRanks []byte
+	//go:embed r50k_base.tiktoken.gz
+	r50kBaseRanks []byte
+)
+
+// Encoding is a byte pair encoding.
+type Encoding struct {
+	name    string
+	split   func(string) []string
+	ranks   map[string]int
+	decoder map[int]string
+	special map[int]string
+}
+
+var (
+	cl100kOnce sync.Once
+	cl100k     *Encoding
+
+	r50kOnce sync.Once
+	r50k     *Encoding
+)
+
+// CL100kBase returns the encoding used by gpt-3.5-turbo, gpt-4 and text-embedding-ada-002.
+func CL100kBase() *Encoding {
+	cl100kOnce.Do(func() {
+		cl100k = mustLoad("cl100k_base", cl100kBaseRanks, splitCL100k, map[int]string{
+			100257: "<|endoftext|>",
+			100258: "<|fim_prefix|>",
+			100259: "<|fim_middle|>",
+			100260: "<|fim_suffix|>",
+			100276: "<|endofprompt|>",
+		})
+	})
+	return cl100k
+}
+
+// R50kBase returns the encoding used by the first generation embedding and search models,
+// e.g. text-similarity-ada-001.
+func R50kBase() *Encoding {
+	r50kOnce.Do(func() {
+		r50k = mustLoad("r50k_base", r50kBaseRanks, splitR50k, map[int]string{
+			50256: "<|endoftext|>",
+		})
+	})
+	return r50k
+}
+
+func mustLoad(name string, data []byte, split func(string) []string, special map[int]string) *Encoding {
+	ranks, err := loadRanks(data)
+	if err != nil {
+		// 内嵌数据损坏属于构建错误
+		panic(fmt.Sprintf("tiktoken: load %s: %v", name, err))
+	}
+	e := &Encoding{
+		name:    name,
+		split:   split,
+		ranks:   ranks,
+		decoder: make(map[int]string, len(ranks)),
+		special: special,
+	}
+	for k, v := range ranks {
+		e.decoder[v] = k
+	}
+	return e
+}
+
+// loadRanks parses a gzipped .tiktoken file: one "base64(token) rank" pair per line.
+func loadRanks(data []byte) (map[string]int, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	ranks := make(map[string]int, 100300)
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		i := bytes.IndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(line[:i]))
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(string(line[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, scanner.Err()
+}
+
+// Name returns the name of the encoding, e.g. "cl100k_base".
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Encode encodes text into tokens. Special tokens in text are encoded as ordinary text.
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range e.split(text) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = e.bytePairEncode(piece, tokens)
+	}
+	return tokens
+}
+
+// Count returns the number of tokens of text.
+func (e *Encoding) Count(text string) int {
+	count := 0
+	for _, piece := range e.split(text) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(e.bytePairMerge(piece)) - 1
+	}
+	return count
+}
+
+// CountMessage returns the number of tokens of a chat message, including the message framing.
+// extra are the other fields of the message, such as tool calls, which are counted as plain text.
+func (e *Encoding) CountMessage(role, content, name string, extra ...string) int {
+	n := TokensPerMessage + e.Count(role) + e.Count(content)
+	if len(name) > 0 {
+		n += TokensPerName + e.Count(name)
+	}
+	for _, s := range extra {
+		n += e.Count(s)
+	}
+	return n
+}
+
+// Decode decodes tokens into bytes. The result may not be valid UTF-8 when tokens
+// is a slice of a longer encoding.
+func (e *Encoding) Decode(tokens []int) []byte {
+	var buf bytes.Buffer
+	for _, token := range tokens {
+		if piece, ok := e.decoder[token]; ok {
+			buf.WriteString(piece)
+		} else if piece, ok := e.special[token]; ok {
+			buf.WriteString(piece)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (e *Encoding) bytePairEncode(piece string, tokens []int) []int {
+	parts := e.bytePairMerge(piece)
+	for i := 0; i < len(parts)-1; i++ {
+		tokens = append(tokens, e.ranks[piece[parts[i].start:parts[i+1].start]])
+	}
+	return tokens
+}
+
+type mergePart struct {
+	start int
+	rank  int
+}
+
+// bytePairMerge merges the bytes of piece by ascending rank, and returns the boundaries
+// of the resulting tokens, with a trailing sentinel at len(piece).
+func (e *Encoding) bytePairMerge(piece string) []mergePart {
+	parts := make([]mergePart, len(piece)+1)
+	for i := range parts {
+		parts[i] = mergePart{start: i, rank: math.MaxInt}
+	}
+
+	rankOf := func(i int) int {
+		// 合并 parts[i] 与 parts[i+1] 后的 rank
+		if i+2 < len(parts) {
+			if rank, ok := e.ranks[piece[parts[i].start:parts[i+2].start]]; ok {
+				return rank
+			}
+		}
+		return math.MaxInt
+	}
+	for i := 0; i < len(parts)-2; i++ {
+		parts[i].rank = rankOf(i)
+	}
+
+	for len(parts) > 1 {
+		minRank, minIdx := math.MaxInt, -1
+		for i := 0; i < len(parts)-1; i++ {
+			if parts[i].rank < minRank {
+				minRank, minIdx = parts[i].rank, i
+			}
+		}
+		if minIdx < 0 {
+			break
+		}
+
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+		parts[minIdx].rank = rankOf(minIdx)
+		if minIdx > 0 {
+			parts[minIdx-1].rank = rankOf(minIdx - 1)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,32 @@
+package tiktoken
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCL100kBase(t *testing.T) {
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"hello world", []int{15339, 1917}},
+		{"tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{"你好，世界！", []int{57668, 53901, 3922, 3574, 244, 98220, 6447}},
+		{"I'm here   \n\n  x", []int{40, 2846, 1618, 35033, 220, 865}},
+		{"", nil},
+	}
+	enc := CL100kBase()
+	for _, tt := range tests {
+		got := enc.Encode(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+		if n := enc.Count(tt.text); n != len(tt.want) {
+			t.Errorf("Count(%q) = %v, want %v", tt.text, n, len(tt.want))
+		}
+		if s := string(enc.Decode(got)); s != tt.text {
+			t.Errorf("Decode(Encode(%q)) = %q", tt.text, s)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package gpt3
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Redactor 脱敏函数，记录日志前作用于请求及响应的内容
+type Redactor func(string) string
+
+var secretPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+|\bsk-[A-Za-z0-9_-]{8,}`)
+
+// RedactSecrets 默认的脱敏函数，遮盖内容中形如 sk-xxx 的 key 及 Bearer token
+func RedactSecrets(s string) string {
+	return secretPattern.ReplaceAllString(s, "${1}"+redactedValue)
+}
+
+const redactedValue = "***"
+
+// sensitiveHeaders 日志中总是遮盖的请求头
+var sensitiveHeaders = []string{"Authorization", "api-key"}
+
+// logRequests 记录每次尝试的日志：Info 级别记录接口、模型、token 数、耗时、状态码及第几次尝试，
+// 失败时为 Warn 级别；Debug 级别另外记录脱敏后的请求头、请求内容及响应内容。
+func logRequests(logger *slog.Logger, redact Redactor) Middleware {
+	if redact == nil {
+		redact = RedactSecrets
+	}
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *Request) (*Response, error) {
+			ctx := req.HTTP.Context()
+			debug := logger.Enabled(ctx, slog.LevelDebug)
+			if debug {
+				logger.LogAttrs(ctx, slog.LevelDebug, "gpt3 request",
+					slog.String("operation", req.Operation),
+					slog.Int("attempt", req.Attempt),
+					slog.Any("header", maskHeader(req.HTTP.Header)),
+					slog.String("body", redact(marshalLog(req.Payload))),
+				)
+			}
+
+			start := time.Now()
+			resp, err := next.Do(req)
+			attrs := []slog.Attr{
+				slog.String("operation", req.Operation),
+				slog.String("model", requestModel(req)),
+				slog.Bool("stream", req.Stream),
+				slog.Int("attempt", req.Attempt),
+				slog.Duration("latency", time.Since(start)),
+			}
+			if resp != nil && resp.HTTP != nil {
+				attrs = append(attrs,
+					slog.Int("status", resp.HTTP.StatusCode),
+					slog.String("request_id", requestID(resp.HTTP.Header)),
+				)
+			}
+			if resp != nil && resp.Result != nil {
+				usage := responseUsage(resp.Result)
+				attrs = append(attrs,
+					slog.Int("prompt_tokens", usage.PromptTokens),
+					slog.Int("cached_tokens", usage.PromptTokensDetails.CachedTokens),
+					slog.Int("completion_tokens", usage.CompletionTokens),
+					slog.Int("total_tokens", usage.TotalTokens),
+				)
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", redact(err.Error())))
+				logger.LogAttrs(ctx, slog.LevelWarn, "gpt3 response", attrs...)
+			} else {
+				logger.LogAttrs(ctx, slog.LevelInfo, "gpt3 response", attrs...)
+			}
+
+			if debug {
+				var body string
+				var apiErr APIError
+				if errors.As(err, &apiErr) {
+					body = string(apiErr.Body)
+				} else if resp != nil && resp.Result != nil {
+					body = marshalLog(resp.Result)
+				}
+				if len(body) > 0 {
+					logger.LogAttrs(ctx, slog.LevelDebug, "gpt3 response body",
+						slog.String("operation", req.Operation),
+						slog.Int("attempt", req.Attempt),
+						slog.String("body", redact(body)),
+					)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// maskHeader 复制请求头并遮盖鉴权信息
+func maskHeader(h http.Header) http.Header {
+	masked := h.Clone()
+	for _, key := range sensitiveHeaders {
+		if len(masked.Values(key)) > 0 {
+			masked.Set(key, redactedValue)
+		}
+	}
+	return masked
+}
+
+func marshalLog(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// requestModel 返回请求使用的模型；completions 及 search 接口的模型在路径中
+func requestModel(req *Request) string {
+	switch p := req.Payload.(type) {
+	case ChatCompletionRequest:
+		return string(p.Model)
+	case EmbeddingsRequest:
+		return p.Model
+	case EditsRequest:
+		return p.Model
+	}
+	if path := strings.Split(req.HTTP.URL.Path, "/"); len(path) >= 3 && path[len(path)-3] == "engines" {
+		return path[len(path)-2]
+	}
+	return ""
+}
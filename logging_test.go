@@ -0,0 +1,51 @@
+package gpt3
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"the answer"}}],"usage":{"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	const secret = "sk-0123456789abcdef"
+	tests := []struct {
+		name     string
+		level    slog.Level
+		contains []string
+		excludes []string
+	}{
+		{"info", slog.LevelInfo, []string{`"operation":"chat.completions"`, `"model":"gpt-3.5-turbo"`, `"attempt":1`, `"status":200`, `"total_tokens":12`}, []string{secret, "my prompt", "the answer"}},
+		{"debug", slog.LevelDebug, []string{`"Authorization":["***"]`, "my prompt", "the answer", "key=***"}, []string{secret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: tt.level}))
+			c := MakeGPT3Client(WithBaseURL(server.URL), WithAuthtoken(secret), WithLogger(logger))
+			_, err := c.DoOnce(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "my prompt key=" + secret}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := buf.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("log missing %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(out, s) {
+					t.Errorf("log contains %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package gpt3
+
+import (
+	"net/http"
+)
+
+// Operation names, see Request.Operation
+const (
+	OperationEngines         = "engines.list"
+	OperationEngine          = "engines.retrieve"
+	OperationListModels      = "models.list"
+	OperationGetModel        = "models.retrieve"
+	OperationDeleteModel     = "models.delete"
+	OperationCompletions     = "completions"
+	OperationChatCompletions = "chat.completions"
+	OperationEdits           = "edits"
+	OperationSearch          = "search"
+	OperationEmbeddings      = "embeddings"
+	OperationCreateImage     = "images.generations"
+	OperationEditImage       = "images.edits"
+	OperationImageVariation  = "images.variations"
+	OperationModerations     = "moderations"
+	OperationTranscriptions  = "audio.transcriptions"
+	OperationTranslations    = "audio.translations"
+	OperationSpeech          = "audio.speech"
+)
+
+// Request 一次接口调用中的一次尝试，重试时每次尝试都会经过中间件
+type Request struct {
+	// Operation 接口名称，如 OperationChatCompletions
+	Operation string
+	// Payload 请求的内容，如 ChatCompletionRequest；没有请求体时为 nil。
+	// Payload 只读，修改它不会改变发送的请求体
+	Payload interface{}
+	// Stream 是否流式请求
+	Stream bool
+	// Attempt 第几次尝试，从1开始
+	Attempt int
+	// HTTP 本次尝试的 HTTP 请求，中间件可以修改其 Header 或替换为新的请求
+	HTTP *http.Request
+
+	// output 响应解码的目标，流式请求为 nil
+	output interface{}
+}
+
+// Response 一次尝试的结果
+type Response struct {
+	// Result 解码后的响应，如 *ChatCompletionResponse；流式请求为 nil
+	Result interface{}
+	// HTTP 响应。非流式请求的 Body 已经读完并关闭；流式请求的 Body 由调用方读取
+	HTTP *http.Response
+}
+
+// Doer 发送一次请求。接口返回错误状态时，error 为 APIError，Response 仍然带有 HTTP 响应
+type Doer interface {
+	Do(req *Request) (*Response, error)
+}
+
+// DoerFunc 以函数实现 Doer
+type DoerFunc func(req *Request) (*Response, error)
+
+func (f DoerFunc) Do(req *Request) (*Response, error) {
+	return f(req)
+}
+
+// Middleware 包装 Doer，可以在请求前后加入日志、鉴权刷新、注入请求头、统计等处理
+//
+//	gpt3.WithMiddleware(func(next gpt3.Doer) gpt3.Doer {
+//		return gpt3.DoerFunc(func(req *gpt3.Request) (*gpt3.Response, error) {
+//			req.HTTP.Header.Set("X-Trace-Id", traceID)
+//			return next.Do(req)
+//		})
+//	})
+type Middleware func(next Doer) Doer
+
+// chain 按顺序组合中间件，第一个中间件在最外层
+func chain(middleware []Middleware, doer Doer) Doer {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		doer = middleware[i](doer)
+	}
+	return doer
+}
@@ -0,0 +1,82 @@
+package gpt3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("X-Tenant") != "t1" {
+			t.Errorf("X-Tenant = %q", r.Header.Get("X-Tenant"))
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+	}))
+	defer server.Close()
+
+	var order []string
+	record := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *Request) (*Response, error) {
+				order = append(order, name)
+				return next.Do(req)
+			})
+		}
+	}
+	var (
+		attempts []int
+		payload  ChatCompletionRequest
+		result   *ChatCompletionResponse
+		statuses []int
+	)
+	inspect := func(next Doer) Doer {
+		return DoerFunc(func(req *Request) (*Response, error) {
+			attempts = append(attempts, req.Attempt)
+			payload, _ = req.Payload.(ChatCompletionRequest)
+			req.HTTP.Header.Set("X-Tenant", "t1")
+
+			resp, err := next.Do(req)
+			if resp != nil {
+				statuses = append(statuses, resp.HTTP.StatusCode)
+				result, _ = resp.Result.(*ChatCompletionResponse)
+			}
+			return resp, err
+		})
+	}
+
+	c := MakeGPT3Client(
+		WithBaseURL(server.URL),
+		WithRetryPolicy(&ExponentialBackoff{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithMiddleware(record("outer"), record("inner")),
+		WithMiddleware(inspect),
+	)
+	resp, err := c.DoOnce(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fmt.Sprint(order), "[outer inner outer inner]"; got != want {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("attempts = %v", attempts)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusBadGateway || statuses[1] != http.StatusOK {
+		t.Errorf("statuses = %v", statuses)
+	}
+	if len(payload.Messages) == 0 || payload.Messages[len(payload.Messages)-1].Content != "hi" {
+		t.Errorf("payload = %+v", payload)
+	}
+	if result == nil || result.Text() != "ok" || resp.Text() != "ok" {
+		t.Errorf("result = %+v", result)
+	}
+}
@@ -1,12 +1,28 @@
 package gpt3
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+)
 
 // APIError represents an error that occured on an API
 type APIError struct {
 	StatusCode int    `json:"status_code"`
 	Message    string `json:"message"`
 	Type       string `json:"type"`
+	// Code is the machine readable error code, e.g. "context_length_exceeded"
+	Code string `json:"code"`
+	// Param is the request parameter the error relates to, if any
+	Param string `json:"param"`
+
+	// RequestID is the x-request-id of the response, useful when contacting support
+	RequestID string `json:"-"`
+	// RateLimit is parsed from the x-ratelimit-* headers of the response
+	RateLimit RateLimitState `json:"-"`
+	// Header 响应头，用于读取 Retry-After 等信息
+	Header http.Header `json:"-"`
+	// Body is the raw response body
+	Body []byte `json:"-"`
 }
 
 func (e APIError) Error() string {
@@ -59,6 +75,8 @@ type CompletionRequest struct {
 	// Whether to stream back results or not. Don't set this value in the request yourself
 	// as it will be overriden depending on if you use CompletionStream or Completion methods.
 	Stream bool `json:"stream,omitempty"`
+	// Options for streaming response. Only set this when you set stream: true.
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 }
 
 // EditsRequest is a request for the edits API
@@ -104,8 +122,8 @@ type LogprobResult struct {
 
 // CompletionResponseChoice is one of the choices returned in the response to the Completions API
 type CompletionResponseChoice struct {
-	Text string `json:"text"`
-	// Index        int           `json:"index"`
+	Text  string `json:"text"`
+	Index int    `json:"index"`
 	// LogProbs     LogprobResult `json:"logprobs"`
 	FinishReason string `json:"finish_reason"`
 }
@@ -120,20 +138,49 @@ type CompletionResponse struct {
 	Usage   CompletionResponseUsage    `json:"usage"`
 }
 
+// first 返回 index 为0的 choice；n>1 的流式数据块中可能没有
+func (cr *CompletionResponse) first() *CompletionResponseChoice {
+	if cr == nil {
+		return nil
+	}
+	for i := range cr.Choices {
+		if cr.Choices[i].Index == 0 {
+			return &cr.Choices[i]
+		}
+	}
+	return nil
+}
+
 func (cr *CompletionResponse) CanContinue() bool {
-	if cr != nil && len(cr.Choices) > 0 {
-		return cr.Choices[0].FinishReason == "length"
+	if c := cr.first(); c != nil {
+		return c.FinishReason == "length"
 	}
 	return false
 }
 
 func (cr *CompletionResponse) Text() string {
-	if cr != nil && len(cr.Choices) > 0 {
-		return cr.Choices[0].Text
+	if c := cr.first(); c != nil {
+		return c.Text
 	}
 	return ""
 }
 
+func (cr *CompletionResponse) AllChoices() []Choice {
+	if cr == nil {
+		return nil
+	}
+	choices := make([]Choice, 0, len(cr.Choices))
+	for _, c := range cr.Choices {
+		choices = append(choices, Choice{
+			Index:        c.Index,
+			Role:         cr.Role(),
+			Text:         c.Text,
+			FinishReason: c.FinishReason,
+		})
+	}
+	return choices
+}
+
 func (cr *CompletionResponse) Role() string {
 	return "user"
 }
@@ -145,6 +192,13 @@ func (cr *CompletionResponse) TotalTokens() int {
 	return 0
 }
 
+func (cr *CompletionResponse) TokenUsage() Usage {
+	if cr != nil {
+		return cr.Usage
+	}
+	return Usage{}
+}
+
 func (cr *CompletionResponse) Reset() {
 	if cr != nil {
 		*cr = CompletionResponse{}
@@ -153,11 +207,7 @@ func (cr *CompletionResponse) Reset() {
 }
 
 // CompletionResponseUsage is the object that returns how many tokens the completion's request used
-type CompletionResponseUsage struct {
-	// PromptTokens     int `json:"prompt_tokens"`
-	// CompletionTokens int `json:"completion_tokens"`
-	TotalTokens int `json:"total_tokens"`
-}
+type CompletionResponseUsage = Usage
 
 // EditsResponse is the full response from a request to the edits API
 type EditsResponse struct {
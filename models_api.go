@@ -0,0 +1,132 @@
+package gpt3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ModelPermission describes what an organization is allowed to do with a model.
+type ModelPermission struct {
+	ID                 string `json:"id"`
+	Object             string `json:"object"`
+	Created            int64  `json:"created"`
+	AllowCreateEngine  bool   `json:"allow_create_engine"`
+	AllowSampling      bool   `json:"allow_sampling"`
+	AllowLogprobs      bool   `json:"allow_logprobs"`
+	AllowSearchIndices bool   `json:"allow_search_indices"`
+	AllowView          bool   `json:"allow_view"`
+	AllowFineTuning    bool   `json:"allow_fine_tuning"`
+	Organization       string `json:"organization"`
+	Group              string `json:"group"`
+	IsBlocking         bool   `json:"is_blocking"`
+}
+
+// Model is a model instance returned by the Models API.
+//
+// See: https://platform.openai.com/docs/api-reference/models/object
+type Model struct {
+	ID         string            `json:"id"`
+	Object     string            `json:"object"`
+	Created    int64             `json:"created"`
+	OwnedBy    string            `json:"owned_by"`
+	Permission []ModelPermission `json:"permission"`
+	Root       string            `json:"root"`
+	Parent     string            `json:"parent"`
+}
+
+// ModelsResponse is returned from the list models API
+type ModelsResponse struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
+
+// DeleteModelResponse is returned after deleting a fine-tuned model
+type DeleteModelResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// ListModels lists the currently available models, and provides basic information about each
+// one such as the owner and permissions.
+func (c *client) ListModels(ctx context.Context) (*ModelsResponse, error) {
+	req, err := c.newRequest(ctx, OperationListModels, "GET", "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	output := new(ModelsResponse)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// GetModel retrieves a model instance, providing basic information about the model such
+// as the owner and permissions.
+func (c *client) GetModel(ctx context.Context, model string) (*Model, error) {
+	req, err := c.newRequest(ctx, OperationGetModel, "GET", fmt.Sprintf("/models/%s", model), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	output := new(Model)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// DeleteModel deletes a fine-tuned model. You must have the Owner role in your organization.
+func (c *client) DeleteModel(ctx context.Context, model string) (*DeleteModelResponse, error) {
+	req, err := c.newRequest(ctx, OperationDeleteModel, "DELETE", fmt.Sprintf("/models/%s", model), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	output := new(DeleteModelResponse)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// ListModels 列出当前可用的模型。
+func (c *GPT3client) ListModels(ctx context.Context) (*ModelsResponse, error) {
+	return c.client.ListModels(ctx)
+}
+
+// GetModel 获取模型信息；model 为空时使用默认引擎。
+func (c *GPT3client) GetModel(ctx context.Context, model string) (*Model, error) {
+	if len(model) == 0 {
+		model = string(c.defaultEngine)
+	}
+	return c.client.GetModel(ctx, model)
+}
+
+// DeleteModel 删除微调模型。
+func (c *GPT3client) DeleteModel(ctx context.Context, model string) (*DeleteModelResponse, error) {
+	if len(model) == 0 {
+		return nil, errors.New("需要指定要删除的模型。")
+	}
+	return c.client.DeleteModel(ctx, model)
+}
+
+// CheckEngine 检查配置的默认引擎在当前账号下是否存在。
+// 建议在 MakeGPT3Client 之后、对外提供服务之前调用，以便在启动阶段发现模型配置错误。
+func (c *GPT3client) CheckEngine(ctx context.Context) error {
+	if len(c.defaultEngine) == 0 {
+		return errors.New("未配置默认引擎。")
+	}
+	if _, err := c.client.GetModel(ctx, string(c.defaultEngine)); err != nil {
+		var apiErr APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return errors.Errorf("模型不存在: %v", c.defaultEngine)
+		}
+		return errors.Wrap(err, "获取模型信息失败")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package gpt3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModelsAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/models":
+			io.WriteString(w, `{"object":"list","data":[{"id":"gpt-4","object":"model","owned_by":"openai"},{"id":"gpt-3.5-turbo","object":"model"}]}`)
+		case r.Method == "GET" && r.URL.Path == "/models/gpt-4":
+			io.WriteString(w, `{"id":"gpt-4","object":"model","owned_by":"openai"}`)
+		case r.Method == "GET" && r.URL.Path == "/models/broken":
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"error":{"message":"forbidden","type":"invalid_request_error"}}`)
+		case r.Method == "DELETE" && r.URL.Path == "/models/ft-mine":
+			io.WriteString(w, `{"id":"ft-mine","object":"model","deleted":true}`)
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/models/"):
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"message":"The model does not exist","type":"invalid_request_error","code":"model_not_found"}}`)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(Gpt4Engine))
+	models, err := c.ListModels(ctx)
+	if err != nil || len(models.Data) != 2 || models.Data[0].OwnedBy != "openai" {
+		t.Errorf("ListModels = %+v, %v", models, err)
+	}
+	// model 为空时使用默认引擎
+	if m, err := c.GetModel(ctx, ""); err != nil || m.ID != "gpt-4" {
+		t.Errorf("GetModel = %+v, %v", m, err)
+	}
+	if err := c.CheckEngine(ctx); err != nil {
+		t.Errorf("CheckEngine = %v", err)
+	}
+
+	missing := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine("gpt-5"))
+	if err := missing.CheckEngine(ctx); err == nil || !strings.Contains(err.Error(), "模型不存在") {
+		t.Errorf("CheckEngine(404) = %v", err)
+	}
+	broken := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine("broken"))
+	if err := broken.CheckEngine(ctx); err == nil || strings.Contains(err.Error(), "模型不存在") {
+		t.Errorf("CheckEngine(403) = %v", err)
+	}
+
+	deleted, err := c.DeleteModel(ctx, "ft-mine")
+	if err != nil || !deleted.Deleted || deleted.ID != "ft-mine" {
+		t.Errorf("DeleteModel = %+v, %v", deleted, err)
+	}
+	if _, err := c.DeleteModel(ctx, ""); err == nil {
+		t.Error("DeleteModel(\"\") succeeded")
+	}
+}
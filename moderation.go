@@ -0,0 +1,213 @@
+package gpt3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ModerationRequest is a request for the moderations API
+type ModerationRequest struct {
+	// The input texts to classify.
+	Input []string `json:"input"`
+	// The moderation model to use, e.g. "omni-moderation-latest" or "text-moderation-latest".
+	// Empty uses the default model of the API.
+	Model string `json:"model,omitempty"`
+}
+
+// ModerationResponse is the response from a moderations request
+type ModerationResponse struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	// Results one result per input, in the same order
+	Results []ModerationResult `json:"results"`
+}
+
+// Flagged reports whether any of the inputs was flagged.
+func (r *ModerationResponse) Flagged() bool {
+	if r == nil {
+		return false
+	}
+	for _, result := range r.Results {
+		if result.Flagged {
+			return true
+		}
+	}
+	return false
+}
+
+// ModerationResult is the classification of one input
+type ModerationResult struct {
+	// Flagged whether the input violates the usage policies in any of the categories
+	Flagged        bool                     `json:"flagged"`
+	Categories     ModerationCategories     `json:"categories"`
+	CategoryScores ModerationCategoryScores `json:"category_scores"`
+}
+
+// FlaggedCategories returns the names of the flagged categories, e.g. "hate/threatening".
+func (r ModerationResult) FlaggedCategories() []string {
+	c := r.Categories
+	var names []string
+	for _, v := range []struct {
+		name    string
+		flagged bool
+	}{
+		{"harassment", c.Harassment},
+		{"harassment/threatening", c.HarassmentThreatening},
+		{"hate", c.Hate},
+		{"hate/threatening", c.HateThreatening},
+		{"illicit", c.Illicit},
+		{"illicit/violent", c.IllicitViolent},
+		{"self-harm", c.SelfHarm},
+		{"self-harm/intent", c.SelfHarmIntent},
+		{"self-harm/instructions", c.SelfHarmInstructions},
+		{"sexual", c.Sexual},
+		{"sexual/minors", c.SexualMinors},
+		{"violence", c.Violence},
+		{"violence/graphic", c.ViolenceGraphic},
+	} {
+		if v.flagged {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+// ModerationCategories whether the input is flagged in each category
+type ModerationCategories struct {
+	Harassment            bool `json:"harassment"`
+	HarassmentThreatening bool `json:"harassment/threatening"`
+	Hate                  bool `json:"hate"`
+	HateThreatening       bool `json:"hate/threatening"`
+	Illicit               bool `json:"illicit"`
+	IllicitViolent        bool `json:"illicit/violent"`
+	SelfHarm              bool `json:"self-harm"`
+	SelfHarmIntent        bool `json:"self-harm/intent"`
+	SelfHarmInstructions  bool `json:"self-harm/instructions"`
+	Sexual                bool `json:"sexual"`
+	SexualMinors          bool `json:"sexual/minors"`
+	Violence              bool `json:"violence"`
+	ViolenceGraphic       bool `json:"violence/graphic"`
+}
+
+// ModerationCategoryScores the model's confidence in each category, between 0 and 1
+type ModerationCategoryScores struct {
+	Harassment            float64 `json:"harassment"`
+	HarassmentThreatening float64 `json:"harassment/threatening"`
+	Hate                  float64 `json:"hate"`
+	HateThreatening       float64 `json:"hate/threatening"`
+	Illicit               float64 `json:"illicit"`
+	IllicitViolent        float64 `json:"illicit/violent"`
+	SelfHarm              float64 `json:"self-harm"`
+	SelfHarmIntent        float64 `json:"self-harm/intent"`
+	SelfHarmInstructions  float64 `json:"self-harm/instructions"`
+	Sexual                float64 `json:"sexual"`
+	SexualMinors          float64 `json:"sexual/minors"`
+	Violence              float64 `json:"violence"`
+	ViolenceGraphic       float64 `json:"violence/graphic"`
+}
+
+// Moderations classifies whether the inputs violate the usage policies.
+//
+// See: https://platform.openai.com/docs/api-reference/moderations
+func (c *client) Moderations(ctx context.Context, request ModerationRequest) (output *ModerationResponse, err error) {
+	ctx, span := c.telemetry.start(ctx, genAIModeration, request.Model, request)
+	defer func() { span.end(output, err) }()
+	req, err := c.newRequest(ctx, OperationModerations, "POST", "/moderations", request)
+	if err != nil {
+		return nil, err
+	}
+
+	output = new(ModerationResponse)
+	if _, err := c.doRequest(req, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// 审核的阶段，见 FlaggedContentError.Stage
+const (
+	ModerationStageInput  = "input"
+	ModerationStageOutput = "output"
+)
+
+// FlaggedContentError 开启 WithModeration 时，用户输入或模型回复未通过审核。
+// errors.Is(err, ErrFlaggedContent) 为 true，通过 errors.As 获取审核结果：
+//
+//	var flagged *gpt3.FlaggedContentError
+//	if errors.As(err, &flagged) {
+//		log.Println(flagged.Stage, flagged.Result.FlaggedCategories())
+//	}
+type FlaggedContentError struct {
+	// Stage 未通过审核的阶段，ModerationStageInput 或 ModerationStageOutput
+	Stage string
+	// Input 未通过审核的内容
+	Input string
+	// Result 审核结果
+	Result ModerationResult
+}
+
+func (e *FlaggedContentError) Error() string {
+	return fmt.Sprintf("%s flagged by moderation: %s", e.Stage, strings.Join(e.Result.FlaggedCategories(), ", "))
+}
+
+// Is makes errors.Is(err, ErrFlaggedContent) work.
+func (e *FlaggedContentError) Is(target error) bool {
+	return target == ErrFlaggedContent
+}
+
+// moderate 审核 inputs，有未通过的内容时返回 *FlaggedContentError
+func (c *GPT3client) moderate(ctx context.Context, stage string, inputs []string) error {
+	var texts []string
+	for _, input := range inputs {
+		if len(strings.TrimSpace(input)) > 0 {
+			texts = append(texts, input)
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	resp, err := c.client.Moderations(ctx, ModerationRequest{
+		Input: texts,
+		Model: c.moderationmodel,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "审核失败:stage=%v", stage)
+	}
+	for i, result := range resp.Results {
+		if result.Flagged && i < len(texts) {
+			return &FlaggedContentError{
+				Stage:  stage,
+				Input:  texts[i],
+				Result: result,
+			}
+		}
+	}
+	return nil
+}
+
+// screenInput 开启输入审核时，审核本轮的用户消息，即最后一条非用户消息之后的用户消息
+func (c *GPT3client) screenInput(ctx context.Context, say []ChatCompletionMessage) error {
+	if !c.moderateinput {
+		return nil
+	}
+	var inputs []string
+	for i := len(say) - 1; i >= 0 && say[i].Role == ChatMessageRoleUser; i-- {
+		inputs = append([]string{say[i].Content}, inputs...)
+	}
+	return c.moderate(ctx, ModerationStageInput, inputs)
+}
+
+// screenOutput 开启输出审核时，审核回复的所有 choice
+func (c *GPT3client) screenOutput(ctx context.Context, resp CompletionResponseInterface) error {
+	if !c.moderateoutput || resp == nil {
+		return nil
+	}
+	var outputs []string
+	for _, choice := range resp.AllChoices() {
+		outputs = append(outputs, choice.Text)
+	}
+	return c.moderate(ctx, ModerationStageOutput, outputs)
+}
@@ -0,0 +1,77 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModeration(t *testing.T) {
+	var moderated [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/moderations" {
+			var req ModerationRequest
+			json.Unmarshal(body, &req)
+			moderated = append(moderated, req.Input)
+			var resp ModerationResponse
+			for _, input := range req.Input {
+				bad := strings.Contains(input, "bad")
+				resp.Results = append(resp.Results, ModerationResult{
+					Flagged:        bad,
+					Categories:     ModerationCategories{Hate: bad},
+					CategoryScores: ModerationCategoryScores{Hate: 0.9},
+				})
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		reply := "fine"
+		if strings.Contains(req.Messages[len(req.Messages)-1].Content, "provoke") {
+			reply = "bad reply"
+		}
+		w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"` + reply + `"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithModeration(true, true, ""))
+	resp, err := c.Moderate(ctx, "ok", "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Flagged() || resp.Results[0].Flagged || strings.Join(resp.Results[1].FlaggedCategories(), ",") != "hate" {
+		t.Errorf("Moderate = %+v", resp)
+	}
+
+	say := []ChatCompletionMessage{
+		{Role: ChatMessageRoleUser, Content: "bad earlier turn"},
+		{Role: ChatMessageRoleAssistant, Content: "..."},
+		{Role: ChatMessageRoleUser, Content: "hello"},
+	}
+	moderated = nil
+	if _, err := c.DoOnce(ctx, say); err != nil {
+		t.Fatal(err)
+	}
+	// 只审核本轮的用户消息及回复
+	if len(moderated) != 2 || moderated[0][0] != "hello" || moderated[1][0] != "fine" {
+		t.Errorf("moderated = %v", moderated)
+	}
+
+	var flagged *FlaggedContentError
+	_, err = c.DoOnce(ctx, []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "bad input"}})
+	if !errors.Is(err, ErrFlaggedContent) || !errors.As(err, &flagged) || flagged.Stage != ModerationStageInput || flagged.Input != "bad input" {
+		t.Errorf("input err = %v", err)
+	}
+	_, err = c.DoOnce(ctx, []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "provoke"}})
+	if !errors.As(err, &flagged) || flagged.Stage != ModerationStageOutput {
+		t.Errorf("output err = %v", err)
+	}
+}
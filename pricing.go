@@ -0,0 +1,75 @@
+package gpt3
+
+import (
+	"strings"
+)
+
+// Price 模型的价格，单位为美元每百万 token
+type Price struct {
+	// Input 提示的价格
+	Input float64
+	// CachedInput 命中缓存的提示的价格；0 表示与 Input 相同
+	CachedInput float64
+	// Output 生成内容的价格
+	Output float64
+}
+
+// Cost 按用量计算花费(美元)
+func (p Price) Cost(u Usage) float64 {
+	cached := u.PromptTokensDetails.CachedTokens
+	cachedPrice := p.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	return (float64(u.PromptTokens-cached)*p.Input +
+		float64(cached)*cachedPrice +
+		float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// Pricing 价格表，key 为 EngineType 或 EmbeddingEngine
+type Pricing map[string]Price
+
+// DefaultPricing 默认的价格表，按 OpenAI 公布的价格；价格变动时请通过 WithPricing 注入新的价格表
+var DefaultPricing = Pricing{
+	string(Gpt35TurboEngine): {Input: 0.5, Output: 1.5},
+	"gpt-3.5-turbo-16k":      {Input: 3, Output: 4},
+	"gpt-3.5-turbo-instruct": {Input: 1.5, Output: 2},
+	string(Gpt4Engine):       {Input: 30, Output: 60},
+	"gpt-4-32k":              {Input: 60, Output: 120},
+	"gpt-4-turbo":            {Input: 10, Output: 30},
+	"gpt-4o":                 {Input: 2.5, CachedInput: 1.25, Output: 10},
+	"gpt-4o-mini":            {Input: 0.15, CachedInput: 0.075, Output: 0.6},
+	"davinci-002":            {Input: 2, Output: 2},
+	"babbage-002":            {Input: 0.4, Output: 0.4},
+	"text-davinci-003":       {Input: 20, Output: 20},
+	TextEmbeddingAda002:      {Input: 0.1},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+}
+
+// Price 返回模型的价格。没有完全相同的模型时，使用最长的前缀匹配，如 "gpt-4-0613" 使用 "gpt-4" 的价格。
+func (p Pricing) Price(model string) (Price, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	var (
+		match string
+		price Price
+		found bool
+	)
+	for name, v := range p {
+		if len(name) > len(match) && strings.HasPrefix(model, name+"-") {
+			match, price, found = name, v, true
+		}
+	}
+	return price, found
+}
+
+// Cost 按模型的价格计算用量的花费(美元)；价格表中没有该模型时返回 false
+func (p Pricing) Cost(model string, u Usage) (float64, bool) {
+	price, ok := p.Price(model)
+	if !ok {
+		return 0, false
+	}
+	return price.Cost(u), true
+}
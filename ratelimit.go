@@ -0,0 +1,191 @@
+package gpt3
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitState is the rate limit state reported by the x-ratelimit-* response headers.
+//
+// See: https://platform.openai.com/docs/guides/rate-limits/rate-limits-in-headers
+type RateLimitState struct {
+	// LimitRequests the maximum number of requests permitted before exhausting the rate limit
+	LimitRequests int
+	// LimitTokens the maximum number of tokens permitted before exhausting the rate limit
+	LimitTokens int
+	// RemainingRequests the remaining number of requests permitted before exhausting the rate limit
+	RemainingRequests int
+	// RemainingTokens the remaining number of tokens permitted before exhausting the rate limit
+	RemainingTokens int
+	// ResetRequests the time until the request rate limit resets to its initial state
+	ResetRequests time.Duration
+	// ResetTokens the time until the token rate limit resets to its initial state
+	ResetTokens time.Duration
+	// UpdatedAt when the headers were received; zero if the response had no rate limit headers
+	UpdatedAt time.Time
+}
+
+// parseRateLimitState 解析 x-ratelimit-* 响应头
+func parseRateLimitState(h http.Header) RateLimitState {
+	var (
+		state RateLimitState
+		found bool
+	)
+	for _, f := range []struct {
+		header string
+		value  *int
+	}{
+		{"x-ratelimit-limit-requests", &state.LimitRequests},
+		{"x-ratelimit-limit-tokens", &state.LimitTokens},
+		{"x-ratelimit-remaining-requests", &state.RemainingRequests},
+		{"x-ratelimit-remaining-tokens", &state.RemainingTokens},
+	} {
+		if n, err := strconv.Atoi(h.Get(f.header)); err == nil {
+			*f.value = n
+			found = true
+		}
+	}
+	if v := h.Get("x-ratelimit-reset-requests"); len(v) > 0 {
+		state.ResetRequests = parseResetDuration(v)
+		found = true
+	}
+	if v := h.Get("x-ratelimit-reset-tokens"); len(v) > 0 {
+		state.ResetTokens = parseResetDuration(v)
+		found = true
+	}
+	if found {
+		state.UpdatedAt = time.Now()
+	}
+	return state
+}
+
+// rateLimiter 客户端限流，同时按每分钟请求数及每分钟 token 数限流
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+func newRateLimiter(requestsPerMinute, tokensPerMinute int) *rateLimiter {
+	l := &rateLimiter{}
+	if requestsPerMinute > 0 {
+		l.requests = newTokenBucket(requestsPerMinute)
+	}
+	if tokensPerMinute > 0 {
+		l.tokens = newTokenBucket(tokensPerMinute)
+	}
+	return l
+}
+
+// wait 等待直到可以发送一个预计消耗 tokens 个 token 的请求，ctx 取消时返回错误
+func (l *rateLimiter) wait(ctx context.Context, tokens int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	delay := l.requests.reserve(now, 1)
+	if d := l.tokens.reserve(now, float64(tokens)); d > delay {
+		delay = d
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// 没有发出的请求不计入
+		l.mu.Lock()
+		l.requests.refund(1)
+		l.tokens.refund(float64(tokens))
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observe 以服务端返回的剩余额度校正本地的估计
+func (l *rateLimiter) observe(state RateLimitState) {
+	if l == nil || state.UpdatedAt.IsZero() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state.LimitRequests > 0 {
+		l.requests.limit(state.UpdatedAt, float64(state.RemainingRequests))
+	}
+	if state.LimitTokens > 0 {
+		l.tokens.limit(state.UpdatedAt, float64(state.RemainingTokens))
+	}
+}
+
+// tokenBucket 令牌桶；容量为一分钟的额度，按秒匀速补充。nil 表示不限制
+type tokenBucket struct {
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		tokens:   float64(perMinute),
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// reserve 预留 n 个令牌，返回需要等待的时间；令牌可以透支，之后的请求会等待更久
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	if n > b.capacity {
+		n = b.capacity
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens += n
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// limit 剩余令牌不超过 remaining
+func (b *tokenBucket) limit(now time.Time, remaining float64) {
+	if b == nil {
+		return
+	}
+	b.refill(now)
+	if remaining < b.tokens {
+		b.tokens = remaining
+	}
+}
@@ -0,0 +1,51 @@
+package gpt3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-limit-requests", "60")
+		w.Header().Set("x-ratelimit-limit-tokens", "150000")
+		w.Header().Set("x-ratelimit-remaining-requests", "59")
+		w.Header().Set("x-ratelimit-remaining-tokens", "149984")
+		w.Header().Set("x-ratelimit-reset-requests", "1s")
+		w.Header().Set("x-ratelimit-reset-tokens", "6m0s")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	if _, err := c.DoOnce(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}); err != nil {
+		t.Fatal(err)
+	}
+	state := c.RateLimit()
+	if state.LimitRequests != 60 || state.RemainingTokens != 149984 || state.ResetTokens != 6*time.Minute || state.UpdatedAt.IsZero() {
+		t.Errorf("RateLimit() = %+v", state)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(60, 0)
+	if err := l.wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	l.observe(RateLimitState{LimitRequests: 60, RemainingRequests: 0, UpdatedAt: time.Now()})
+
+	// 额度用完，下一个请求需要等待约1秒
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, 100); err != context.DeadlineExceeded {
+		t.Errorf("wait() error = %v", err)
+	}
+
+	var nilLimiter *rateLimiter
+	if err := nilLimiter.wait(context.Background(), 1<<20); err != nil {
+		t.Errorf("nil limiter wait() error = %v", err)
+	}
+}
@@ -1,29 +1,181 @@
 package gpt3
 
 import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // RetryHandle 重试机制的处理类型
 type RetryHandle func() error
 
+// RetryPolicy 重试策略，决定一次失败之后是否重试，以及重试前等待多久
+type RetryPolicy interface {
+	// Backoff 返回第 attempt 次(从1开始)尝试失败后的等待时间；elapsed 为第一次尝试开始至今的时间。
+	// 返回 false 表示不再重试。
+	Backoff(attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff 指数退避重试策略，等待时间为 [0, min(MaxDelay, BaseDelay*2^(attempt-1))) 内的随机值(full jitter)。
+// 429/503 响应带有 Retry-After 或 x-ratelimit-reset-* 头时，按服务端要求的时间等待。
+type ExponentialBackoff struct {
+	// MaxAttempts 最多尝试次数，包括第一次
+	MaxAttempts int
+	// BaseDelay 第一次重试的等待上限
+	BaseDelay time.Duration
+	// MaxDelay 单次等待的上限
+	MaxDelay time.Duration
+	// MaxElapsed 从第一次尝试开始，超过这个时间不再重试；0 表示不限制
+	MaxElapsed time.Duration
+	// Retryable 判断错误能否重试；nil 时使用 IsRetryable
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy 默认的重试策略
+func DefaultRetryPolicy(maxAttempts int) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Second / 2,
+		MaxDelay:    8 * time.Second,
+		MaxElapsed:  time.Minute,
+	}
+}
+
+func (p *ExponentialBackoff) Backoff(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+
+	delay := retryAfter(err)
+	if delay <= 0 {
+		ceil := p.MaxDelay
+		if shift := attempt - 1; shift < 32 && p.BaseDelay<<shift < ceil {
+			ceil = p.BaseDelay << shift
+		}
+		if ceil > 0 {
+			delay = time.Duration(rand.Int63n(int64(ceil)))
+		}
+	}
+	if p.MaxElapsed > 0 && elapsed+delay > p.MaxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// IsRetryable 判断错误能否重试：网络错误、超时、限流(额度用尽除外)及服务端错误可以重试；
+// 其他接口错误(如参数错误、鉴权失败)以及 ctx 取消不能重试。
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// 响应已经成功返回，只是无法解码，重试也不会有不同的结果
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return false
+	}
+
+	var apiErr APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return errors.Is(apiErr, ErrRateLimited) ||
+		errors.Is(apiErr, ErrServerOverloaded) ||
+		apiErr.StatusCode == http.StatusRequestTimeout ||
+		apiErr.StatusCode == http.StatusConflict
+}
+
+// retryAfter 返回 429/503 响应头要求的等待时间，没有要求时返回0
+func retryAfter(err error) time.Duration {
+	var apiErr APIError
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests && apiErr.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	h := apiErr.Header
+	if v := h.Get("retry-after-ms"); len(v) > 0 {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if v := h.Get("Retry-After"); len(v) > 0 {
+		if s, err := strconv.ParseFloat(v, 64); err == nil && s > 0 {
+			return time.Duration(s * float64(time.Second))
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+
+	// 额度用完的那一类限流，等到其重置
+	var delay time.Duration
+	for _, kind := range []string{"requests", "tokens"} {
+		if h.Get("x-ratelimit-remaining-"+kind) != "0" {
+			continue
+		}
+		if d := parseResetDuration(h.Get("x-ratelimit-reset-" + kind)); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// parseResetDuration 解析 x-ratelimit-reset-* 头，形如 "1s"、"6m0s"、"20ms"
+func parseResetDuration(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+		return d
+	}
+	return 0
+}
+
 // 尝试重试机制
+// ctx：取消时立即停止重试
+// policy：重试策略
 // fn：处理事件方法
-// retries：重试次数
-// delay：重试间隔
 // 返回值为可选的 err 或 nil
-func retry(fn RetryHandle, retries int, delay time.Duration) error {
-	if err := fn(); err != nil {
-		retries--
-		if retries <= 0 {
+func retry(ctx context.Context, policy RetryPolicy, fn RetryHandle) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		delay, ok := policy.Backoff(attempt, time.Since(start), err)
+		if !ok {
 			return err
 		}
 
-		// delay += (time.Duration(rand.Int63n(int64(delay)))) / 2
-		time.Sleep(delay)
-
-		return retry(fn, retries, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "停止重试, 上次错误: %v", err)
+		case <-timer.C:
+		}
 	}
-
-	return nil
 }
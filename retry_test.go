@@ -1,32 +1,164 @@
 package gpt3
 
 import (
+	"context"
 	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
 func Test_retry(t *testing.T) {
 	type args struct {
-		fn      RetryHandle
-		retries int
-		delay   time.Duration
+		fails  int
+		policy RetryPolicy
 	}
 	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
+		name      string
+		args      args
+		wantCalls int
+		wantErr   bool
 	}{
-		{"", args{func() error {
-			t.Log("time", time.Now())
-			return errors.New("")
-		}, 0, time.Second * 2}, false},
+		{"success", args{0, DefaultRetryPolicy(3)}, 1, false},
+		{"no retry", args{1, DefaultRetryPolicy(1)}, 1, true},
+		{"retry then success", args{2, DefaultRetryPolicy(3)}, 3, false},
+		{"retry exhausted", args{5, DefaultRetryPolicy(3)}, 3, true},
+		{"not retryable", args{5, &ExponentialBackoff{MaxAttempts: 3, Retryable: func(error) bool { return false }}}, 1, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := retry(tt.args.fn, tt.args.retries, tt.args.delay); (err != nil) != tt.wantErr {
+			if p, ok := tt.args.policy.(*ExponentialBackoff); ok {
+				p.BaseDelay = time.Millisecond
+			}
+			calls := 0
+			err := retry(context.Background(), tt.args.policy, func() error {
+				calls++
+				if calls <= tt.args.fails {
+					return errors.New("")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
 				t.Errorf("retry() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if calls != tt.wantCalls {
+				t.Errorf("retry() calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func Test_retryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	policy := &ExponentialBackoff{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	start := time.Now()
+	err := retry(ctx, policy, func() error { return errors.New("") })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("retry() error = %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("retry() did not stop on cancel")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", errors.New("connection reset"), true},
+		{"canceled", context.Canceled, false},
+		{"bad request", APIError{StatusCode: 400}, false},
+		{"unauthorized", APIError{StatusCode: 401}, false},
+		{"rate limited", APIError{StatusCode: 429, Type: "requests"}, true},
+		{"quota", APIError{StatusCode: 429, Type: "insufficient_quota"}, false},
+		{"server error", APIError{StatusCode: 502}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffRetryAfter(t *testing.T) {
+	policy := DefaultRetryPolicy(3)
+	err := APIError{StatusCode: 429, Header: http.Header{}}
+	err.Header.Set("Retry-After", "2")
+	if delay, ok := policy.Backoff(1, 0, err); !ok || delay != 2*time.Second {
+		t.Errorf("Retry-After: delay = %v, ok = %v", delay, ok)
+	}
+
+	err.Header = http.Header{}
+	err.Header.Set("x-ratelimit-remaining-tokens", "0")
+	err.Header.Set("x-ratelimit-reset-tokens", "6m0s")
+	if _, ok := policy.Backoff(1, 0, err); ok {
+		t.Errorf("reset beyond MaxElapsed should not retry")
+	}
+	err.Header.Set("x-ratelimit-reset-tokens", "1.5s")
+	if delay, ok := policy.Backoff(1, 0, err); !ok || delay != 1500*time.Millisecond {
+		t.Errorf("x-ratelimit-reset-tokens: delay = %v, ok = %v", delay, ok)
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	const fails = 2
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) <= fails {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"boom","type":"server_error"}}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/embeddings":
+			w.Write([]byte(`{"data":[{"embedding":[0.1],"index":0}]}`))
+		default:
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(
+		WithBaseURL(server.URL),
+		WithRetryPolicy(&ExponentialBackoff{MaxAttempts: fails + 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	)
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"chat", func() error {
+			_, err := c.DoOnce(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}})
+			return err
+		}},
+		{"embeddings", func() error {
+			_, err := c.Embeddings(context.Background(), EmbeddingsRequest{Input: []string{"hi"}})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodies = nil
+			if err := tt.call(); err != nil {
+				t.Fatal(err)
+			}
+			if len(bodies) != fails+1 {
+				t.Fatalf("attempts = %v, want %v", len(bodies), fails+1)
+			}
+			for i, body := range bodies {
+				if len(body) == 0 || body != bodies[0] {
+					t.Errorf("attempt %v body = %q, want %q", i+1, body, bodies[0])
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,90 @@
+package gpt3
+
+import (
+	"context"
+	"io"
+)
+
+// TTSModel the default text to speech model
+const TTSModel = "tts-1"
+
+// SpeechVoice the voice to use when generating the audio
+type SpeechVoice string
+
+const (
+	VoiceAlloy   SpeechVoice = "alloy"
+	VoiceEcho    SpeechVoice = "echo"
+	VoiceFable   SpeechVoice = "fable"
+	VoiceOnyx    SpeechVoice = "onyx"
+	VoiceNova    SpeechVoice = "nova"
+	VoiceShimmer SpeechVoice = "shimmer"
+)
+
+// SpeechFormat the format of the generated audio
+type SpeechFormat string
+
+const (
+	SpeechFormatMP3  SpeechFormat = "mp3"
+	SpeechFormatOpus SpeechFormat = "opus"
+	SpeechFormatAAC  SpeechFormat = "aac"
+	SpeechFormatFLAC SpeechFormat = "flac"
+	SpeechFormatWAV  SpeechFormat = "wav"
+	// SpeechFormatPCM raw samples in 24kHz, 16-bit signed, little-endian, without header
+	SpeechFormatPCM SpeechFormat = "pcm"
+)
+
+// SpeechRequest is a request for the audio speech API
+type SpeechRequest struct {
+	// ID of the model to use, e.g. TTSModel or "tts-1-hd".
+	Model string `json:"model"`
+	// The text to generate audio for. The maximum length is 4096 characters.
+	Input string `json:"input"`
+	// The voice to use when generating the audio.
+	Voice SpeechVoice `json:"voice"`
+	// The format of the audio, defaults to mp3.
+	ResponseFormat SpeechFormat `json:"response_format,omitempty"`
+	// The speed of the generated audio, from 0.25 to 4.0, defaults to 1.0.
+	Speed float64 `json:"speed,omitempty"`
+}
+
+// CreateSpeech generates audio from the input text. The returned body streams the audio
+// as the server produces it and must be closed by the caller.
+//
+// See: https://platform.openai.com/docs/api-reference/audio/createSpeech
+func (c *client) CreateSpeech(ctx context.Context, request SpeechRequest) (output io.ReadCloser, err error) {
+	ctx, span := c.telemetry.start(ctx, genAISpeech, request.Model, request)
+	defer func() {
+		if err != nil {
+			span.end(nil, err)
+		}
+	}()
+	req, err := c.newRequest(ctx, OperationSpeech, "POST", "/audio/speech", request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.sendStream(req)
+	if err != nil {
+		return nil, err
+	}
+	return &spanReadCloser{ReadCloser: body, span: span}, nil
+}
+
+// spanReadCloser 读取出错或关闭时结束 span
+type spanReadCloser struct {
+	io.ReadCloser
+	span *operationSpan
+}
+
+func (r *spanReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		r.span.end(nil, err)
+	}
+	return n, err
+}
+
+func (r *spanReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.end(nil, nil)
+	return err
+}
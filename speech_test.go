@@ -0,0 +1,46 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpeech(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req SpeechRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/audio/speech" || req.Model != TTSModel || req.Voice != VoiceAlloy || req.ResponseFormat != SpeechFormatOpus || req.Speed != 1.5 {
+			t.Errorf("%v %+v", r.URL.Path, req)
+		}
+		w.Header().Set("Content-Type", "audio/ogg")
+		// 分块写出，客户端边生成边读取
+		for _, part := range []string{"Ogg", "S\x00"} {
+			io.WriteString(w, part)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+
+	audio, err := c.Speech(context.Background(), SpeechRequest{Input: "你好", ResponseFormat: SpeechFormatOpus, Speed: 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(audio)
+	audio.Close()
+	if err != nil || string(data) != "OggS\x00" {
+		t.Errorf("audio = %q, %v", data, err)
+	}
+
+	if _, err := c.Speech(context.Background(), SpeechRequest{Input: strings.Repeat("字", 4097)}); err == nil {
+		t.Error("overlong input accepted")
+	}
+	if _, err := c.Speech(context.Background(), SpeechRequest{Input: "hi", Speed: 5}); err == nil {
+		t.Error("speed 5 accepted")
+	}
+}
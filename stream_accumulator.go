@@ -0,0 +1,136 @@
+package gpt3
+
+import (
+	"sort"
+	"strings"
+)
+
+// StreamAccumulator 把流式响应的数据块拼装成与非流式接口形式相同的 ChatCompletionResponse，
+// 按 choice 的 index 分别拼接内容与工具调用。
+//
+//	acc := NewStreamAccumulator()
+//	err := client.DoStream(ctx, say, acc.Add)
+//	resp := acc.Response()
+//
+// StreamAccumulator 不支持并发调用。
+type StreamAccumulator struct {
+	id      string
+	created int64
+	model   string
+	usage   ChatCompletionResponseUsage
+	choices map[int]*accumulatedChoice
+	calls   toolCallBuffer
+}
+
+type accumulatedChoice struct {
+	role         string
+	content      strings.Builder
+	finishReason string
+}
+
+// NewStreamAccumulator 创建 StreamAccumulator
+func NewStreamAccumulator() *StreamAccumulator {
+	return &StreamAccumulator{
+		choices: map[int]*accumulatedChoice{},
+	}
+}
+
+// Add 加入一个数据块，支持 *ChatStreamCompletionResponse 及 *CompletionResponse，其他类型忽略。
+// 数据块的内容会被复制，调用后可以复用 chunk，因此 Add 可以直接作为 DoStream 的回调。
+func (a *StreamAccumulator) Add(chunk CompletionResponseInterface) {
+	switch cr := chunk.(type) {
+	case *ChatStreamCompletionResponse:
+		if cr == nil {
+			return
+		}
+		a.addMeta(cr.ID, cr.Created, cr.Model, cr.Usage)
+		for _, c := range cr.Choices {
+			choice := a.choice(c.Index)
+			choice.add(c.Message.Role, c.Message.Content, c.FinishReason)
+			for _, delta := range c.Message.ToolCalls {
+				a.calls.addToolCall(c.Index, delta)
+			}
+			if delta := c.Message.FunctionCall; delta != nil {
+				a.calls.addFunctionCall(c.Index, *delta)
+			}
+		}
+	case *CompletionResponse:
+		if cr == nil {
+			return
+		}
+		a.addMeta("", 0, "", cr.Usage)
+		for _, c := range cr.Choices {
+			a.choice(c.Index).add(ChatMessageRoleAssistant, c.Text, c.FinishReason)
+		}
+	}
+}
+
+func (a *StreamAccumulator) addMeta(id string, created int64, model string, usage ChatCompletionResponseUsage) {
+	if len(a.id) == 0 {
+		a.id = id
+	}
+	if a.created == 0 {
+		a.created = created
+	}
+	if len(a.model) == 0 {
+		a.model = model
+	}
+	if usage.TotalTokens > 0 {
+		// 开启 include_usage 时，最后一个数据块携带整个请求的用量
+		a.usage = usage
+	}
+}
+
+func (a *StreamAccumulator) choice(index int) *accumulatedChoice {
+	choice, ok := a.choices[index]
+	if !ok {
+		choice = &accumulatedChoice{}
+		a.choices[index] = choice
+	}
+	return choice
+}
+
+func (c *accumulatedChoice) add(role, content, finishReason string) {
+	if len(c.role) == 0 {
+		c.role = role
+	}
+	c.content.WriteString(content)
+	if len(finishReason) > 0 {
+		c.finishReason = finishReason
+	}
+}
+
+// Response 返回目前为止拼装的结果，choices 按 index 排序。
+func (a *StreamAccumulator) Response() *ChatCompletionResponse {
+	resp := &ChatCompletionResponse{
+		ID:      a.id,
+		Object:  "chat.completion",
+		Created: a.created,
+		Model:   a.model,
+		Usage:   a.usage,
+	}
+	for index, c := range a.choices {
+		role := c.role
+		if len(role) == 0 {
+			role = ChatMessageRoleAssistant
+		}
+		msg := ChatCompletionResponseChoiceMessage{
+			Role:      role,
+			Content:   c.content.String(),
+			ToolCalls: append([]ToolCall(nil), a.calls.calls[index]...),
+		}
+		if call := a.calls.functions[index]; call != nil {
+			fc := *call
+			msg.FunctionCall = &fc
+		}
+		resp.Choices = append(resp.Choices, ChatCompletionResponseChoice{
+			Index:        index,
+			Message:      msg,
+			FinishReason: c.finishReason,
+		})
+	}
+	sort.Slice(resp.Choices, func(i, j int) bool {
+		return resp.Choices[i].Index < resp.Choices[j].Index
+	})
+	return resp
+}
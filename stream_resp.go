@@ -2,40 +2,218 @@ package gpt3
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
+// StreamOnData 读取流式响应，每个数据块调用一次 onData。
+// 所有数据块复用同一个 output，onData 返回后其内容即被覆盖，不要保留；需要保留数据块时请使用 Stream。
 func StreamOnData(streamData io.ReadCloser, output CompletionResponseInterface, onData func(CompletionResponseInterface)) error {
-	reader := newEventStreamReader(streamData, 1<<16)
-	defer streamData.Close()
+	stream := newStream(context.Background(), streamData, func() CompletionResponseInterface {
+		output.Reset()
+		return output
+	})
+	return stream.forEach(onData)
+}
+
+// Stream 流式响应。
+// 每次 Recv 返回一个新的数据块，可以安全地保留或交给其他 goroutine。
+// Stream 不支持并发 Recv；Close 可以在任意 goroutine 中调用。
+type Stream struct {
+	ctx      context.Context
+	body     io.ReadCloser
+	reader   *EventStreamReader
+	newChunk func() CompletionResponseInterface
+	calls    toolCallBuffer
+	// span 在流结束时结束；nil 表示不记录
+	span *operationSpan
+	// onUsage 收到携带用量的数据块时调用；nil 表示不记录
+	onUsage func(Usage)
+	// onEstimate 流结束时仍未收到用量，以估计的用量调用一次：提示为 prompt 个 token，生成为已收到内容的 token 数；nil 表示不估计
+	onEstimate func(Usage)
+	prompt     int
+
+	// mu 保护 generated 及 sawUsage，Close 可能在其他 goroutine 中调用
+	mu        sync.Mutex
+	generated int
+	sawUsage  bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newStream(ctx context.Context, body io.ReadCloser, newChunk func() CompletionResponseInterface) *Stream {
+	s := &Stream{
+		ctx:      ctx,
+		body:     body,
+		reader:   newEventStreamReader(body, 1<<16),
+		newChunk: newChunk,
+		done:     make(chan struct{}),
+	}
+	if ctx.Done() != nil {
+		// ctx 取消时立即关闭连接，不必等到下一次读取超时
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Close()
+			case <-s.done:
+			}
+		}()
+	}
+	return s
+}
 
-LOOP:
+// Recv 返回下一个数据块；流正常结束或已关闭时返回 io.EOF，ctx 取消时返回 ctx.Err()。
+func (s *Stream) Recv() (CompletionResponseInterface, error) {
 	for {
-		event, err := reader.ReadEvent()
+		event, err := s.reader.ReadEvent()
 		if err != nil {
-			if err == io.EOF {
-				break LOOP
+			if ctxErr := s.ctx.Err(); ctxErr != nil {
+				s.span.end(nil, ctxErr)
+				return nil, ctxErr
+			}
+			if err == io.EOF || s.closed() {
+				s.Close()
+				return nil, io.EOF
 			}
-			return errors.Wrap(err, "ReadEvent")
+			err = errors.Wrap(err, "ReadEvent")
+			s.span.end(nil, err)
+			return nil, err
 		}
 
-		// If we get an error, ignore it.
-		var msg *Event
-		if msg, err = processEvent(event); err != nil {
-			return errors.Wrap(err, "ProcessEvent")
+		msg, err := processEvent(event)
+		if err != nil {
+			err = errors.Wrap(err, "ProcessEvent")
+			s.span.end(nil, err)
+			return nil, err
 		}
-		output.Reset()
 		if bytes.Equal(msg.Data, doneSequence) {
-			break LOOP
+			s.Close()
+			return nil, io.EOF
+		}
+		if len(msg.Data) == 0 {
+			// 注释或心跳
+			continue
 		}
+
+		output := s.newChunk()
 		if err := json.Unmarshal(msg.Data, output); err != nil {
-			return errors.Errorf("invalid json stream data: %v", err)
+			err = errors.Errorf("invalid json stream data: %v", err)
+			s.span.end(nil, err)
+			return nil, err
+		}
+		if chunk, ok := output.(*ChatStreamCompletionResponse); ok {
+			s.calls.add(chunk)
+		}
+		s.span.chunk(output)
+		if u := output.TokenUsage(); !u.IsZero() {
+			s.mu.Lock()
+			s.sawUsage = true
+			s.mu.Unlock()
+			if s.onUsage != nil {
+				s.onUsage(u)
+			}
+		} else if s.onEstimate != nil {
+			s.addGenerated(output)
+		}
+		return output, nil
+	}
+}
+
+// forEach 读取所有数据块，每个数据块调用一次 onData，结束后关闭流
+func (s *Stream) forEach(onData func(CompletionResponseInterface)) error {
+	defer s.Close()
+	for {
+		chunk, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		onData(chunk)
+	}
+}
+
+// Chan 在后台 goroutine 中读取数据块并写入返回的 channel，读取结束后关闭 channel。
+// 读取出错时，错误写入 errs 后关闭 errs；正常结束时 errs 直接关闭。
+// 不再读取 channel 时需要调用 Close，否则后台 goroutine 无法退出。
+func (s *Stream) Chan() (chunks <-chan CompletionResponseInterface, errs <-chan error) {
+	out := make(chan CompletionResponseInterface)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		for {
+			chunk, err := s.Recv()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case out <- chunk:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// Close 关闭流及底层连接，可以重复调用。
+func (s *Stream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.body.Close()
+		// ctx 取消时由后台 goroutine 关闭，span 记录为取消
+		s.span.end(nil, s.ctx.Err())
+		s.estimate()
+	})
+	return err
+}
+
+// addGenerated 累加数据块中生成内容的 token 数；工具调用只在携带结束原因的数据块中拼接完整后计算
+func (s *Stream) addGenerated(chunk CompletionResponseInterface) {
+	n := 0
+	for _, choice := range chunk.AllChoices() {
+		n += countTokens(choice.Text)
+		for _, call := range choice.ToolCalls {
+			n += countTokens(call.Function.Name) + countTokens(call.Function.Arguments)
+		}
+		if call := choice.FunctionCall; call != nil {
+			n += countTokens(call.Name) + countTokens(call.Arguments)
 		}
+	}
+	s.mu.Lock()
+	s.generated += n
+	s.mu.Unlock()
+}
+
+// estimate 流结束时仍未收到用量，按估计的用量调用 onEstimate
+func (s *Stream) estimate() {
+	if s.onEstimate == nil {
+		return
+	}
+	s.mu.Lock()
+	sawUsage, generated := s.sawUsage, s.generated
+	s.mu.Unlock()
+	if sawUsage {
+		return
+	}
+	s.onEstimate(Usage{PromptTokens: s.prompt, CompletionTokens: generated, TotalTokens: s.prompt + generated})
+}
 
-		onData(output)
+func (s *Stream) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
 	}
-	return nil
 }
@@ -0,0 +1,112 @@
+package gpt3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const chatStreamBody = `data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"你"}}]}
+
+data: {"choices":[{"index":0,"delta":{"content":"好"}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"ci"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"北京\"}"}}]}}]}
+
+data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`
+
+func TestStreamRecv(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, chatStreamBody)
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	stream, err := c.OpenStream(context.Background(), []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var chunks []CompletionResponseInterface
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 5 {
+		t.Fatalf("got %v chunks", len(chunks))
+	}
+	// 每个数据块都是新的值
+	if chunks[0].Text() != "你" || chunks[1].Text() != "好" {
+		t.Errorf("texts = %q %q", chunks[0].Text(), chunks[1].Text())
+	}
+
+	last := chunks[4].(*ChatStreamCompletionResponse).Choices[0]
+	if len(last.ToolCalls) != 1 {
+		t.Fatalf("tool calls = %+v", last.ToolCalls)
+	}
+	if call := last.ToolCalls[0]; call.ID != "call_1" || call.Function.Name != "weather" || call.Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("tool call = %+v", call)
+	}
+
+	acc := NewStreamAccumulator()
+	for _, chunk := range chunks {
+		acc.Add(chunk)
+	}
+	resp := acc.Response()
+	if resp.Text() != "你好" || resp.Role() != ChatMessageRoleAssistant || resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("accumulated = %+v", resp)
+	}
+	if calls := resp.Choices[0].Message.ToolCalls; len(calls) != 1 || calls[0].Function.Arguments != `{"city":"北京"}` {
+		t.Errorf("accumulated tool calls = %+v", calls)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	stream, err := c.OpenStream(ctx, []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	chunks, errs := stream.Chan()
+	if chunk := <-chunks; chunk.Text() != "a" {
+		t.Fatalf("text = %q", chunk.Text())
+	}
+	cancel()
+
+	select {
+	case _, ok := <-chunks:
+		if ok {
+			t.Fatal("unexpected chunk")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after cancel")
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("err = %v", err)
+	}
+}
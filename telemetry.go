@@ -0,0 +1,343 @@
+package gpt3
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/sunreaver/go-gpt3"
+
+// OpenTelemetry GenAI semantic convention attributes
+// See: https://opentelemetry.io/docs/specs/semconv/gen-ai/
+const (
+	attrOperationName         = attribute.Key("gen_ai.operation.name")
+	attrSystem                = attribute.Key("gen_ai.system")
+	attrRequestModel          = attribute.Key("gen_ai.request.model")
+	attrRequestMaxTokens      = attribute.Key("gen_ai.request.max_tokens")
+	attrRequestTemperature    = attribute.Key("gen_ai.request.temperature")
+	attrRequestTopP           = attribute.Key("gen_ai.request.top_p")
+	attrRequestChoiceCount    = attribute.Key("gen_ai.request.choice.count")
+	attrRequestStopSequences  = attribute.Key("gen_ai.request.stop_sequences")
+	attrResponseID            = attribute.Key("gen_ai.response.id")
+	attrResponseModel         = attribute.Key("gen_ai.response.model")
+	attrResponseFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	attrResponseTTFT          = attribute.Key("gen_ai.response.time_to_first_token")
+	attrUsageInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	attrUsageOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+	attrUsageCachedTokens     = attribute.Key("gen_ai.usage.cache_read.input_tokens")
+	attrTokenType             = attribute.Key("gen_ai.token.type")
+	attrErrorType             = attribute.Key("error.type")
+	attrStream                = attribute.Key("gpt3.stream")
+	attrAttempt               = attribute.Key("gpt3.attempt")
+)
+
+// gen_ai.operation.name 的取值
+const (
+	genAIChat            = "chat"
+	genAITextCompletion  = "text_completion"
+	genAIEmbeddings      = "embeddings"
+	genAIImageGeneration = "image_generation"
+	genAIModeration      = "moderation"
+	genAITranscription   = "transcription"
+	genAITranslation     = "translation"
+	genAISpeech          = "speech"
+)
+
+// 语义约定建议的直方图分桶
+var (
+	durationBuckets = []float64{0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12, 10.24, 20.48, 40.96, 81.92}
+	tokenBuckets    = []float64{1, 4, 16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864}
+)
+
+// telemetry 记录 OpenTelemetry 的 span 及指标；nil 表示不记录
+type telemetry struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	tokens   metric.Int64Histogram
+	ttft     metric.Float64Histogram
+	retries  metric.Int64Counter
+}
+
+// newTelemetry 创建 telemetry，tp/mp 为 nil 时使用 otel 全局的 provider。
+// 创建指标失败时交给 otel.Handle 处理，对应的指标不再记录。
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+	t := &telemetry{
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	var err error
+	if t.duration, err = meter.Float64Histogram("gen_ai.client.operation.duration",
+		metric.WithDescription("GenAI operation duration"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		otel.Handle(err)
+	}
+	if t.tokens, err = meter.Int64Histogram("gen_ai.client.token.usage",
+		metric.WithDescription("Measures number of input and output tokens used"),
+		metric.WithUnit("{token}"),
+		metric.WithExplicitBucketBoundaries(tokenBuckets...)); err != nil {
+		otel.Handle(err)
+	}
+	if t.ttft, err = meter.Float64Histogram("gen_ai.client.time_to_first_token",
+		metric.WithDescription("Time to receive the first chunk of a streaming response"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		otel.Handle(err)
+	}
+	if t.retries, err = meter.Int64Counter("gen_ai.client.retries",
+		metric.WithDescription("Number of retried requests"),
+		metric.WithUnit("{request}")); err != nil {
+		otel.Handle(err)
+	}
+	return t
+}
+
+// start 开始一个接口调用的 span，ctx 取消或重试都包含在 span 之内
+func (t *telemetry) start(ctx context.Context, operation, model string, payload interface{}) (context.Context, *operationSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &operationSpan{
+		t:     t,
+		start: time.Now(),
+		metricAttrs: []attribute.KeyValue{
+			attrOperationName.String(operation),
+			attrSystem.String("openai"),
+			attrRequestModel.String(model),
+		},
+	}
+
+	name := operation
+	if len(model) > 0 {
+		name += " " + model
+	}
+	attrs := append(requestAttributes(payload), s.metricAttrs...)
+	ctx, s.span = t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	s.ctx = ctx
+	return ctx, s
+}
+
+// middleware 每次重试都记录一个 span 事件并计数
+func (t *telemetry) middleware(next Doer) Doer {
+	return DoerFunc(func(req *Request) (*Response, error) {
+		if req.Attempt > 1 {
+			ctx := req.HTTP.Context()
+			trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(attrAttempt.Int(req.Attempt)))
+			if t.retries != nil {
+				t.retries.Add(ctx, 1, metric.WithAttributes(attrOperationName.String(req.Operation)))
+			}
+		}
+		return next.Do(req)
+	})
+}
+
+func requestAttributes(payload interface{}) []attribute.KeyValue {
+	var (
+		attrs       []attribute.KeyValue
+		maxTokens   *int
+		temperature *float32
+		topP        *float32
+		n           *int
+		stop        []string
+		stream      bool
+	)
+	switch p := payload.(type) {
+	case ChatCompletionRequest:
+		maxTokens, temperature, topP, n, stop, stream = p.MaxTokens, p.Temperature, p.TopP, p.N, p.Stop, p.Stream
+	case CompletionRequest:
+		maxTokens, temperature, topP, n, stop, stream = p.MaxTokens, p.Temperature, p.TopP, p.N, p.Stop, p.Stream
+	case CreateImageReq:
+		attrs = append(attrs, attrRequestChoiceCount.Int(p.N))
+	case EditImageReq:
+		attrs = append(attrs, attrRequestChoiceCount.Int(p.N))
+	case ImageVariationReq:
+		attrs = append(attrs, attrRequestChoiceCount.Int(p.N))
+	}
+	if maxTokens != nil {
+		attrs = append(attrs, attrRequestMaxTokens.Int(*maxTokens))
+	}
+	if temperature != nil {
+		attrs = append(attrs, attrRequestTemperature.Float64(float64(*temperature)))
+	}
+	if topP != nil {
+		attrs = append(attrs, attrRequestTopP.Float64(float64(*topP)))
+	}
+	if n != nil && *n > 1 {
+		attrs = append(attrs, attrRequestChoiceCount.Int(*n))
+	}
+	if len(stop) > 0 {
+		attrs = append(attrs, attrRequestStopSequences.StringSlice(stop))
+	}
+	if stream {
+		attrs = append(attrs, attrStream.Bool(true))
+	}
+	return attrs
+}
+
+// operationSpan 一次接口调用的 span；nil 表示不记录。
+// 流式请求在流结束时才结束 span，期间记录收到第一个数据块的时间。
+type operationSpan struct {
+	t           *telemetry
+	ctx         context.Context
+	span        trace.Span
+	start       time.Time
+	metricAttrs []attribute.KeyValue
+
+	mu            sync.Mutex
+	firstChunk    time.Time
+	responseID    string
+	responseModel string
+	finishReasons []string
+	usage         Usage
+	endOnce       sync.Once
+}
+
+// chunk 记录流式响应的一个数据块
+func (s *operationSpan) chunk(chunk CompletionResponseInterface) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.firstChunk.IsZero() {
+		s.firstChunk = time.Now()
+	}
+	s.collect(chunk)
+}
+
+// collect 收集响应中的 id、模型、结束原因及 token 用量
+func (s *operationSpan) collect(result interface{}) {
+	var id, model string
+	switch r := result.(type) {
+	case *ChatCompletionResponse:
+		if r == nil {
+			return
+		}
+		id, model = r.ID, r.Model
+		for _, c := range r.Choices {
+			s.addFinishReason(c.FinishReason)
+		}
+	case *ChatStreamCompletionResponse:
+		if r == nil {
+			return
+		}
+		id, model = r.ID, r.Model
+		for _, c := range r.Choices {
+			s.addFinishReason(c.FinishReason)
+		}
+	case *CompletionResponse:
+		if r == nil {
+			return
+		}
+		for _, c := range r.Choices {
+			s.addFinishReason(c.FinishReason)
+		}
+	}
+	if len(s.responseID) == 0 {
+		s.responseID = id
+	}
+	if len(s.responseModel) == 0 {
+		s.responseModel = model
+	}
+	if usage := responseUsage(result); usage.PromptTokens+usage.CompletionTokens > 0 {
+		s.usage = usage
+	}
+}
+
+func (s *operationSpan) addFinishReason(reason string) {
+	if len(reason) > 0 {
+		s.finishReasons = append(s.finishReasons, reason)
+	}
+}
+
+// end 结束 span 并记录指标，可以重复调用，只有第一次生效
+func (s *operationSpan) end(result interface{}, err error) {
+	if s == nil {
+		return
+	}
+	s.endOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.collect(result)
+		s.finish(err)
+	})
+}
+
+func (s *operationSpan) finish(err error) {
+	var attrs []attribute.KeyValue
+	metricAttrs := s.metricAttrs
+	if len(s.responseID) > 0 {
+		attrs = append(attrs, attrResponseID.String(s.responseID))
+	}
+	if len(s.responseModel) > 0 {
+		attrs = append(attrs, attrResponseModel.String(s.responseModel))
+		metricAttrs = append(metricAttrs, attrResponseModel.String(s.responseModel))
+	}
+	if len(s.finishReasons) > 0 {
+		attrs = append(attrs, attrResponseFinishReasons.StringSlice(s.finishReasons))
+	}
+	if s.usage.PromptTokens+s.usage.CompletionTokens > 0 {
+		attrs = append(attrs,
+			attrUsageInputTokens.Int(s.usage.PromptTokens),
+			attrUsageOutputTokens.Int(s.usage.CompletionTokens),
+		)
+		if cached := s.usage.PromptTokensDetails.CachedTokens; cached > 0 {
+			attrs = append(attrs, attrUsageCachedTokens.Int(cached))
+		}
+	}
+	if !s.firstChunk.IsZero() {
+		ttft := s.firstChunk.Sub(s.start).Seconds()
+		attrs = append(attrs, attrResponseTTFT.Float64(ttft))
+		if s.t.ttft != nil {
+			s.t.ttft.Record(s.ctx, ttft, metric.WithAttributes(metricAttrs...))
+		}
+	}
+	if err != nil {
+		errType := errorType(err)
+		attrs = append(attrs, attrErrorType.String(errType))
+		metricAttrs = append(metricAttrs, attrErrorType.String(errType))
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.SetAttributes(attrs...)
+
+	if s.t.duration != nil {
+		s.t.duration.Record(s.ctx, time.Since(s.start).Seconds(), metric.WithAttributes(metricAttrs...))
+	}
+	if s.t.tokens != nil && s.usage.PromptTokens+s.usage.CompletionTokens > 0 {
+		s.t.tokens.Record(s.ctx, int64(s.usage.PromptTokens), metric.WithAttributes(append(metricAttrs, attrTokenType.String("input"))...))
+		s.t.tokens.Record(s.ctx, int64(s.usage.CompletionTokens), metric.WithAttributes(append(metricAttrs, attrTokenType.String("output"))...))
+	}
+	s.span.End()
+}
+
+// errorType 返回 error.type 属性：接口错误为其类型或状态码，ctx 取消为 ctx 的错误，其他为 _OTHER
+func errorType(err error) string {
+	var apiErr APIError
+	switch {
+	case errors.As(err, &apiErr) && len(apiErr.Code) > 0:
+		return apiErr.Code
+	case errors.As(err, &apiErr) && len(apiErr.Type) > 0:
+		return apiErr.Type
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "_OTHER"
+}
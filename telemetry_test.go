@@ -0,0 +1,106 @@
+package gpt3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOpenTelemetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte(`"stream":true`)) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, chatStreamBody)
+			return
+		}
+		w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-3.5-turbo-0613","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":9,"completion_tokens":3,"total_tokens":12}}`))
+	}))
+	defer server.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	c := MakeGPT3Client(
+		WithBaseURL(server.URL),
+		WithOpenTelemetry(tp, mp),
+		WithRetryPolicy(&ExponentialBackoff{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	)
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+	if _, err := c.DoOnce(context.Background(), say); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DoStream(context.Background(), say, func(CompletionResponseInterface) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("got %v spans", len(ended))
+	}
+	chat := spanAttributes(ended[0])
+	if ended[0].Name() != "chat gpt-3.5-turbo" || len(ended[0].Events()) != 1 {
+		t.Errorf("span = %v, events = %v", ended[0].Name(), ended[0].Events())
+	}
+	if chat[attrUsageInputTokens].AsInt64() != 9 || chat[attrUsageOutputTokens].AsInt64() != 3 ||
+		chat[attrResponseModel].AsString() != "gpt-3.5-turbo-0613" || chat[attrResponseFinishReasons].AsStringSlice()[0] != "stop" {
+		t.Errorf("chat attributes = %v", chat)
+	}
+	stream := spanAttributes(ended[1])
+	if !stream[attrStream].AsBool() || stream[attrResponseFinishReasons].AsStringSlice()[0] != "tool_calls" {
+		t.Errorf("stream attributes = %v", stream)
+	}
+	if _, ok := stream[attrResponseTTFT]; !ok {
+		t.Errorf("stream attributes missing %v", attrResponseTTFT)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	if d, ok := metrics["gen_ai.client.operation.duration"].(metricdata.Histogram[float64]); !ok {
+		t.Errorf("missing duration")
+	} else if count := d.DataPoints[0].Count + d.DataPoints[len(d.DataPoints)-1].Count; len(d.DataPoints) > 2 || count != 2 {
+		t.Errorf("duration count = %v", count)
+	}
+	if d, ok := metrics["gen_ai.client.token.usage"].(metricdata.Histogram[int64]); !ok || len(d.DataPoints) != 2 {
+		t.Errorf("token usage = %+v", metrics["gen_ai.client.token.usage"])
+	}
+	if d, ok := metrics["gen_ai.client.retries"].(metricdata.Sum[int64]); !ok || d.DataPoints[0].Value != 1 {
+		t.Errorf("retries = %+v", metrics["gen_ai.client.retries"])
+	}
+	if _, ok := metrics["gen_ai.client.time_to_first_token"]; !ok {
+		t.Errorf("missing time_to_first_token")
+	}
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
@@ -0,0 +1,127 @@
+// Package tokenizer counts, encodes and decodes tokens the same way as the OpenAI models do.
+// The BPE ranks are embedded, so it works fully offline.
+//
+//	tk, err := tokenizer.ForEngine(gpt3.Gpt4Engine)
+//	if err != nil {
+//		return err
+//	}
+//	n := tk.CountTokens("你好，世界！")
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sunreaver/go-gpt3"
+	"github.com/sunreaver/go-gpt3/internal/tiktoken"
+)
+
+// ErrUnsupportedModel is returned for models whose encoding is not embedded.
+var ErrUnsupportedModel = errors.New("tokenizer: unsupported model")
+
+// Tokenizer encodes and decodes text for one model.
+type Tokenizer struct {
+	enc *tiktoken.Encoding
+}
+
+// encodings by model name prefix, more specific prefixes first
+var encodings = []struct {
+	prefix string
+	enc    func() *tiktoken.Encoding
+}{
+	{"gpt-4o", nil},
+	{"gpt-4", tiktoken.CL100kBase},
+	{"gpt-3.5-turbo", tiktoken.CL100kBase},
+	{"gpt-35-turbo", tiktoken.CL100kBase}, // Azure
+	{"text-embedding-ada-002", tiktoken.CL100kBase},
+	{"text-embedding-3-", tiktoken.CL100kBase},
+	{"text-similarity-", tiktoken.R50kBase},
+	{"text-search-", tiktoken.R50kBase},
+	{"code-search-", tiktoken.R50kBase},
+}
+
+// ForModel returns the tokenizer of the named model, or ErrUnsupportedModel.
+func ForModel(model string) (*Tokenizer, error) {
+	for _, e := range encodings {
+		if !strings.HasPrefix(model, e.prefix) {
+			continue
+		}
+		if e.enc == nil {
+			break
+		}
+		return &Tokenizer{enc: e.enc()}, nil
+	}
+	return nil, errors.Wrapf(ErrUnsupportedModel, "%q", model)
+}
+
+// ForEngine returns the tokenizer of a chat or completion engine.
+func ForEngine(engine gpt3.EngineType) (*Tokenizer, error) {
+	return ForModel(string(engine))
+}
+
+// ForEmbedding returns the tokenizer of an embedding engine.
+func ForEmbedding(engine gpt3.EmbeddingEngine) (*Tokenizer, error) {
+	return ForModel(string(engine))
+}
+
+// Encoding returns the name of the encoding, e.g. "cl100k_base".
+func (t *Tokenizer) Encoding() string {
+	return t.enc.Name()
+}
+
+// Encode encodes text into tokens. Special tokens such as <|endoftext|> are encoded as plain text.
+func (t *Tokenizer) Encode(text string) []int {
+	return t.enc.Encode(text)
+}
+
+// Decode decodes tokens into text. Decoding part of an encoding may cut a multi-byte character,
+// which shows up as U+FFFD when the text is printed.
+func (t *Tokenizer) Decode(tokens []int) string {
+	return string(t.enc.Decode(tokens))
+}
+
+// CountTokens returns the number of tokens of text.
+func (t *Tokenizer) CountTokens(text string) int {
+	return t.enc.Count(text)
+}
+
+// Chunk splits text into consecutive chunks of at most size tokens each, e.g. to embed a long document.
+// Chunks end on character boundaries, so a chunk may be a few tokens shorter than size, or longer
+// when a single character takes more than size tokens.
+func (t *Tokenizer) Chunk(text string, size int) []string {
+	if size <= 0 {
+		return nil
+	}
+	tokens := t.enc.Encode(text)
+	var chunks []string
+	for len(tokens) > 0 {
+		n := size
+		if n > len(tokens) {
+			n = len(tokens)
+		}
+		chunk := t.enc.Decode(tokens[:n])
+		// 不在多字节字符中间切分
+		for i := n - 1; i > 0 && !utf8.Valid(chunk); i-- {
+			if prev := t.enc.Decode(tokens[:i]); utf8.Valid(prev) {
+				n, chunk = i, prev
+			}
+		}
+		// 一个字符就超过 size 个 token 时，这个块包含整个字符
+		for n < len(tokens) && !utf8.Valid(chunk) {
+			n++
+			chunk = t.enc.Decode(tokens[:n])
+		}
+		chunks = append(chunks, string(chunk))
+		tokens = tokens[n:]
+	}
+	return chunks
+}
+
+// CountChatTokens returns the number of prompt tokens the chat models bill for messages,
+// following the chat message framing rules: every message costs a few tokens on top of its role
+// and content, and every reply is primed with a few more.
+func CountChatTokens(messages []gpt3.ChatCompletionMessage) int {
+	// 与客户端截断历史时使用同一个计算，避免两者不一致
+	return gpt3.CountMessagesTokens(messages) + tiktoken.TokensReplyPriming
+}
@@ -0,0 +1,136 @@
+package tokenizer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sunreaver/go-gpt3"
+)
+
+func TestForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4", "cl100k_base"},
+		{"gpt-4-0613", "cl100k_base"},
+		{"gpt-3.5-turbo-16k", "cl100k_base"},
+		{"gpt-35-turbo", "cl100k_base"},
+		{"text-embedding-3-small", "cl100k_base"},
+		{"text-search-ada-doc-001", "r50k_base"},
+		{"code-search-babbage-code-001", "r50k_base"},
+		{"gpt-4o", ""},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		tk, err := ForModel(tt.model)
+		if tt.want == "" {
+			if !errors.Is(err, ErrUnsupportedModel) {
+				t.Errorf("ForModel(%q) err = %v", tt.model, err)
+			}
+			continue
+		}
+		if err != nil || tk.Encoding() != tt.want {
+			t.Errorf("ForModel(%q) = %v, %v, want %v", tt.model, tk, err, tt.want)
+		}
+	}
+
+	if tk, err := ForEngine(gpt3.Gpt4Engine); err != nil || tk.Encoding() != "cl100k_base" {
+		t.Errorf("ForEngine = %v, %v", tk, err)
+	}
+	if tk, err := ForEmbedding(gpt3.TextEmbeddingAda002); err != nil || tk.Encoding() != "cl100k_base" {
+		t.Errorf("ForEmbedding = %v, %v", tk, err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		model string
+		text  string
+		want  []int
+	}{
+		{"gpt-4", "hello world", []int{15339, 1917}},
+		{"gpt-4", "tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{"gpt-4", "你好，世界！", []int{57668, 53901, 3922, 3574, 244, 98220, 6447}},
+		{"text-search-ada-doc-001", "hello world", []int{31373, 995}},
+		{"text-search-ada-doc-001", "tiktoken is great!", []int{83, 1134, 30001, 318, 1049, 0}},
+	}
+	for _, tt := range tests {
+		tk, err := ForModel(tt.model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := tk.Encode(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: Encode(%q) = %v, want %v", tk.Encoding(), tt.text, got, tt.want)
+		}
+		if n := tk.CountTokens(tt.text); n != len(tt.want) {
+			t.Errorf("%v: CountTokens(%q) = %v", tk.Encoding(), tt.text, n)
+		}
+		if s := tk.Decode(got); s != tt.text {
+			t.Errorf("%v: Decode(Encode(%q)) = %q", tk.Encoding(), tt.text, s)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	texts := []string{
+		"I'm here   \n\n  x",
+		"He said: \"don't\" 12345 times...",
+		"混合 English 与中文，还有 emoji 🎉！",
+		"\t缩进\r\n换行",
+	}
+	for _, model := range []string{"gpt-4", "text-search-ada-doc-001"} {
+		tk, _ := ForModel(model)
+		for _, text := range texts {
+			if s := tk.Decode(tk.Encode(text)); s != text {
+				t.Errorf("%v: round trip %q = %q", tk.Encoding(), text, s)
+			}
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	tk, _ := ForModel("gpt-4")
+	text := strings.Repeat("你好，世界！The quick brown fox jumps over the lazy dog. 🎉", 20)
+	for _, size := range []int{1, 3, 7, 50} {
+		chunks := tk.Chunk(text, size)
+		if strings.Join(chunks, "") != text {
+			t.Errorf("size %v: chunks do not join back to the text", size)
+		}
+		for i, chunk := range chunks {
+			if !utf8.ValidString(chunk) {
+				t.Errorf("size %v: chunk %v cuts a character: %q", size, i, chunk)
+			}
+			// 只有一个字符跨越超过 size 个 token，在 size 处切分会截断字符时，块才可以超过 size
+			if tokens := tk.Encode(chunk); len(tokens) > size && utf8.ValidString(tk.Decode(tokens[:size])) {
+				t.Errorf("size %v: chunk %v has %v tokens", size, i, len(tokens))
+			}
+		}
+	}
+	if chunks := tk.Chunk(text, 0); chunks != nil {
+		t.Errorf("Chunk(0) = %v", chunks)
+	}
+	if chunks := tk.Chunk("", 10); len(chunks) != 0 {
+		t.Errorf("Chunk(\"\") = %v", chunks)
+	}
+}
+
+func TestCountChatTokens(t *testing.T) {
+	// 每条消息3个 token 的格式开销，role 及内容各自计数，回复引导3个 token
+	msgs := []gpt3.ChatCompletionMessage{{Role: gpt3.ChatMessageRoleUser, Content: "hello world"}}
+	if n := CountChatTokens(msgs); n != 3+1+2+3 {
+		t.Errorf("CountChatTokens = %v", n)
+	}
+	// 与客户端截断历史时的计算一致
+	msgs = append(msgs, gpt3.ChatCompletionMessage{
+		Role:      gpt3.ChatMessageRoleAssistant,
+		ToolCalls: []gpt3.ToolCall{{ID: "call_1", Function: gpt3.FunctionCall{Name: "weather", Arguments: `{"city":"北京"}`}}},
+	}, gpt3.ChatCompletionMessage{Role: gpt3.ChatMessageRoleTool, Content: "晴", ToolCallID: "call_1"})
+	if n, want := CountChatTokens(msgs), gpt3.CountMessagesTokens(msgs)+3; n != want {
+		t.Errorf("CountChatTokens = %v, want %v", n, want)
+	}
+}
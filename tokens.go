@@ -0,0 +1,115 @@
+package gpt3
+
+import (
+	"unicode/utf8"
+
+	"github.com/sunreaver/go-gpt3/internal/tiktoken"
+)
+
+// countTokens 计算文本的 token 数
+func countTokens(text string) int {
+	return tiktoken.CL100kBase().Count(text)
+}
+
+// CountMessagesTokens 计算 chat 消息的 token 数，包括每条消息的格式开销，不包括回复的引导开销。
+// 用于实现 TruncationStrategy。
+func CountMessagesTokens(msgs []ChatCompletionMessage) int {
+	n := 0
+	for _, msg := range msgs {
+		n += countMessageTokens(msg)
+	}
+	return n
+}
+
+// countMessageTokens 计算一条 chat 消息的 token 数，包括消息的格式开销
+func countMessageTokens(msg ChatCompletionMessage) int {
+	return tiktoken.CL100kBase().CountMessage(msg.Role, msg.Content, msg.Name, messageExtra(msg)...)
+}
+
+// messageExtra 返回消息中除 role、content、name 以外计入 token 的字段
+func messageExtra(msg ChatCompletionMessage) []string {
+	var extra []string
+	for _, call := range msg.ToolCalls {
+		extra = append(extra, call.ID, call.Function.Name, call.Function.Arguments)
+	}
+	if msg.FunctionCall != nil {
+		extra = append(extra, msg.FunctionCall.Name, msg.FunctionCall.Arguments)
+	}
+	if len(msg.ToolCallID) > 0 {
+		extra = append(extra, msg.ToolCallID)
+	}
+	return extra
+}
+
+// tokenEstimate 发送前估计的 token 数
+type tokenEstimate struct {
+	// prompt 提示的 token 数
+	prompt int
+	// completion 最多生成的 token 数
+	completion int
+}
+
+func (e tokenEstimate) total() int {
+	return e.prompt + e.completion
+}
+
+// estimateChatTokens 估计请求消耗的 token 数：提示的 token 数及最多生成的 token 数
+func estimateChatTokens(request ChatCompletionRequest) tokenEstimate {
+	e := tokenEstimate{prompt: tiktoken.TokensReplyPriming}
+	for _, msg := range request.Messages {
+		e.prompt += countMessageTokens(msg)
+	}
+	e.completion = maxCompletionTokens(request.MaxTokens, request.N)
+	return e
+}
+
+func estimateCompletionTokens(request CompletionRequest) tokenEstimate {
+	var e tokenEstimate
+	for _, prompt := range request.Prompt {
+		e.prompt += countTokens(prompt)
+	}
+	e.completion = maxCompletionTokens(request.MaxTokens, request.N)
+	return e
+}
+
+func estimateEmbeddingsTokens(request EmbeddingsRequest) tokenEstimate {
+	var e tokenEstimate
+	for _, input := range request.Input {
+		e.prompt += countTokens(input)
+	}
+	return e
+}
+
+func maxCompletionTokens(maxTokens, n *int) int {
+	if maxTokens == nil {
+		return 0
+	}
+	if n != nil && *n > 1 {
+		return *maxTokens * *n
+	}
+	return *maxTokens
+}
+
+// clipTokens 保留 text 末尾不超过 max 个 token 的内容，不会截断 UTF-8 字符
+func clipTokens(text string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	enc := tiktoken.CL100kBase()
+	tokens := enc.Encode(text)
+	if len(tokens) <= max {
+		return text
+	}
+
+	tail := enc.Decode(tokens[len(tokens)-max:])
+	// 去掉开头被截断的字符
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	// 重新编码后的 token 数可能略有变化
+	for len(tail) > 0 && enc.Count(string(tail)) > max {
+		_, size := utf8.DecodeRune(tail)
+		tail = tail[size:]
+	}
+	return string(tail)
+}
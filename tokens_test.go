@@ -0,0 +1,158 @@
+package gpt3
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sunreaver/go-gpt3/internal/tiktoken"
+)
+
+func TestClipTokens(t *testing.T) {
+	chinese := strings.Repeat("人工智能正在改变世界，", 20)
+	tests := []struct {
+		name string
+		text string
+		max  int
+	}{
+		{"chinese 1", chinese, 1},
+		{"chinese 7", chinese, 7},
+		{"chinese 50", chinese, 50},
+		{"emoji", strings.Repeat("👍🏻🎉", 10), 5},
+		{"short", "你好", 10},
+		{"zero", chinese, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clipTokens(tt.text, tt.max)
+			if !utf8.ValidString(got) {
+				t.Errorf("clipTokens() = %q, invalid UTF-8", got)
+			}
+			if n := countTokens(got); n > tt.max {
+				t.Errorf("clipTokens() = %v tokens, max %v", n, tt.max)
+			}
+			if !strings.HasSuffix(tt.text, got) {
+				t.Errorf("clipTokens() = %q, not a suffix", got)
+			}
+			if countTokens(tt.text) <= tt.max && got != tt.text {
+				t.Errorf("clipTokens() = %q, want unchanged", got)
+			}
+		})
+	}
+}
+
+func TestMakeChatCompletionRequest(t *testing.T) {
+	system := ChatCompletionMessage{Role: ChatMessageRoleSystem, Content: "你是一个助手"}
+	long := strings.Repeat("人工智能正在改变世界，", 100)
+	calls := []ToolCall{{ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "search", Arguments: `{"q":"天气"}`}}}
+	tests := []struct {
+		name    string
+		maxsend int
+		say     []ChatCompletionMessage
+		// check 检查截断后的消息(不含系统提示)
+		check func(t *testing.T, say []ChatCompletionMessage)
+		err   error
+	}{
+		{
+			name:    "fits",
+			maxsend: 4096,
+			say:     []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: long}},
+			check: func(t *testing.T, say []ChatCompletionMessage) {
+				if len(say) != 1 || say[0].Content != long {
+					t.Errorf("say = %+v", say)
+				}
+			},
+		},
+		{
+			name:    "clip chinese",
+			maxsend: 200,
+			say: []ChatCompletionMessage{
+				{Role: ChatMessageRoleUser, Content: long},
+				{Role: ChatMessageRoleAssistant, Content: "好的"},
+				{Role: ChatMessageRoleUser, Content: "总结一下"},
+			},
+			check: func(t *testing.T, say []ChatCompletionMessage) {
+				if len(say) != 3 || len(say[0].Content) == 0 || len(say[0].Content) >= len(long) {
+					t.Errorf("say = %+v", say)
+				}
+			},
+		},
+		{
+			name:    "last overflows",
+			maxsend: 100,
+			say:     []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: long}},
+			err:     ErrContextLengthExceeded,
+		},
+		{
+			name:    "drop tool messages",
+			maxsend: 150,
+			say: []ChatCompletionMessage{
+				{Role: ChatMessageRoleUser, Content: "查一下天气"},
+				{Role: ChatMessageRoleAssistant, ToolCalls: calls},
+				{Role: ChatMessageRoleTool, ToolCallID: "call_1", Content: long},
+				{Role: ChatMessageRoleAssistant, Content: "今天晴"},
+				{Role: ChatMessageRoleUser, Content: "明天呢"},
+			},
+			check: func(t *testing.T, say []ChatCompletionMessage) {
+				// 放不下的工具结果整条丢弃，之前的工具调用也不再发送
+				if len(say) != 2 || say[0].Content != "今天晴" {
+					t.Errorf("say = %+v", say)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := MakeGPT3Client(WithMaxsend(tt.maxsend))
+			say := append([]ChatCompletionMessage(nil), tt.say...)
+			request, err := c.makeChatCompletionRequest(context.Background(), system, say...)
+			if !reflect.DeepEqual(say, tt.say) {
+				t.Errorf("say modified: %+v", say)
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(request.Messages[0], system) {
+				t.Errorf("system = %+v", request.Messages[0])
+			}
+			if n := CountMessagesTokens(request.Messages) + tiktoken.TokensReplyPriming; n > tt.maxsend {
+				t.Errorf("%v tokens, maxsend %v", n, tt.maxsend)
+			}
+			for _, msg := range request.Messages {
+				if !utf8.ValidString(msg.Content) {
+					t.Errorf("invalid UTF-8: %q", msg.Content)
+				}
+			}
+			tt.check(t, request.Messages[1:])
+		})
+	}
+}
+
+func TestMakeCompletionRequest(t *testing.T) {
+	c := MakeGPT3Client(WithDefaultEngine("davinci-002"), WithMaxsend(50))
+	system := "你是一个助手。"
+	say := []ChatCompletionMessage{
+		{Role: ChatMessageRoleSystem, Content: system},
+		{Role: ChatMessageRoleUser, Content: strings.Repeat("人工智能正在改变世界，", 20)},
+		{Role: ChatMessageRoleUser, Content: "最后一句"},
+	}
+	prompt := c.makeCompletionRequest(say).Prompt[0]
+	if !strings.HasPrefix(prompt, system) || !strings.HasSuffix(prompt, "最后一句") {
+		t.Errorf("prompt = %q", prompt)
+	}
+	if !utf8.ValidString(prompt) {
+		t.Errorf("prompt = %q, invalid UTF-8", prompt)
+	}
+	if n := countTokens(strings.TrimPrefix(prompt, system)) + countTokens(system); n > 50 {
+		t.Errorf("prompt = %v tokens, maxsend 50", n)
+	}
+}
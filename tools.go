@@ -0,0 +1,175 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxToolIterations 默认工具调用最大轮数
+const DefaultMaxToolIterations = 8
+
+// ToolHandler 处理一次工具调用；arguments 为模型生成的 JSON 参数，返回值作为 tool 消息的内容发回模型。
+type ToolHandler func(ctx context.Context, arguments string) (string, error)
+
+// ToolRegistry 工具注册表，供 DoWithTools 使用。
+type ToolRegistry struct {
+	mu       sync.RWMutex
+	tools    []Tool
+	handlers map[string]ToolHandler
+}
+
+// NewToolRegistry 创建空的工具注册表
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		handlers: map[string]ToolHandler{},
+	}
+}
+
+// Register 注册工具；parameters 为参数的 JSON Schema。
+// 同名工具会被覆盖。
+func (r *ToolRegistry) Register(name, description string, parameters interface{}, handler ToolHandler) error {
+	if len(name) == 0 {
+		return errors.New("工具名不能为空。")
+	}
+	if handler == nil {
+		return errors.Errorf("工具 %v 缺少处理函数。", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tool := Tool{
+		Type: ToolTypeFunction,
+		Function: FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+	if _, ok := r.handlers[name]; ok {
+		for i := range r.tools {
+			if r.tools[i].Function.Name == name {
+				r.tools[i] = tool
+			}
+		}
+	} else {
+		r.tools = append(r.tools, tool)
+	}
+	r.handlers[name] = handler
+	return nil
+}
+
+// RegisterTool 注册以结构体 T 为参数的工具，参数的 JSON Schema 由 SchemaOf 从 T 推导。
+func RegisterTool[T any](r *ToolRegistry, name, description string, fn func(ctx context.Context, args T) (string, error)) error {
+	if fn == nil {
+		return errors.Errorf("工具 %v 缺少处理函数。", name)
+	}
+	var zero T
+	return r.Register(name, description, SchemaOf(zero), func(ctx context.Context, arguments string) (string, error) {
+		var args T
+		if len(strings.TrimSpace(arguments)) > 0 {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", errors.Wrap(err, "invalid arguments")
+			}
+		}
+		return fn(ctx, args)
+	})
+}
+
+// Tools 返回已注册的工具，按注册顺序排列
+func (r *ToolRegistry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Tool(nil), r.tools...)
+}
+
+// Call 执行一次工具调用，返回需要发回模型的 tool 消息。
+// 处理函数的错误同样作为消息内容返回，以便模型自行纠正。
+func (r *ToolRegistry) Call(ctx context.Context, call ToolCall) ChatCompletionMessage {
+	r.mu.RLock()
+	handler, ok := r.handlers[call.Function.Name]
+	r.mu.RUnlock()
+
+	var content string
+	if !ok {
+		content = fmt.Sprintf("error: unknown tool %q", call.Function.Name)
+	} else if result, err := handler(ctx, call.Function.Arguments); err != nil {
+		content = fmt.Sprintf("error: %v", err)
+	} else {
+		content = result
+	}
+	return ChatCompletionMessage{
+		Role:       ChatMessageRoleTool,
+		Content:    content,
+		ToolCallID: call.ID,
+	}
+}
+
+// DoWithTools 带工具调用的对话。
+// 模型请求调用工具时，依次执行 registry 中对应的处理函数，并把结果以 tool 消息追加到对话中再次请求，
+// 直到模型给出最终回答，或超过 WithMaxToolIterations 设置的轮数。
+// 返回最终的回答(其 Usage 为所有轮次的用量之和)，以及本次追加到对话中的全部消息(包括最终回答)。registry 不能为 nil。
+func (c *GPT3client) DoWithTools(ctx context.Context, say []ChatCompletionMessage, registry *ToolRegistry) (CompletionResponseInterface, []ChatCompletionMessage, error) {
+	if len(say) == 0 {
+		return nil, nil, errors.New("您得说些什么。")
+	}
+	if registry == nil {
+		return nil, nil, errors.New("未指定工具。")
+	}
+	if !c.isChatEngine() {
+		return nil, nil, errors.Errorf("引擎 %v 不支持工具调用。", c.defaultEngine)
+	}
+	if err := c.screenInput(ctx, say); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, usage := TrackUsage(ctx)
+	history := append([]ChatCompletionMessage(nil), say...)
+	var appended []ChatCompletionMessage
+	for i := 0; i < c.maxtooliterations; i++ {
+		request, err := c.makeChatCompletionRequest(ctx, ChatCompletionMessage{
+			Role:    ChatMessageRoleSystem,
+			Content: c.systemprompt,
+		}, history...)
+		if err != nil {
+			return nil, appended, err
+		}
+		request.Tools = registry.Tools()
+
+		resp, err := c.client.ChatCompletion(ctx, request)
+		if err != nil {
+			return nil, appended, err
+		}
+		// 返回的用量为所有轮次之和
+		resp.Usage = usage.Usage()
+		if len(resp.Choices) == 0 {
+			return resp, appended, nil
+		}
+
+		msg := resp.Choices[0].Message
+		reply := ChatCompletionMessage{
+			Role:      ChatMessageRoleAssistant,
+			Content:   msg.Content,
+			ToolCalls: msg.ToolCalls,
+		}
+		history = append(history, reply)
+		appended = append(appended, reply)
+		if len(msg.ToolCalls) == 0 {
+			if err := c.screenOutput(ctx, resp); err != nil {
+				return nil, appended, err
+			}
+			return resp, appended, nil
+		}
+
+		for _, call := range msg.ToolCalls {
+			result := registry.Call(ctx, call)
+			history = append(history, result)
+			appended = append(appended, result)
+		}
+	}
+	return nil, appended, errors.Errorf("工具调用超过 %v 轮仍未得到回答。", c.maxtooliterations)
+}
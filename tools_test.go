@@ -0,0 +1,106 @@
+package gpt3
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaOf(t *testing.T) {
+	type args struct {
+		City  string   `json:"city" description:"城市名"`
+		Unit  string   `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+		Days  *int     `json:"days"`
+		Tags  []string `json:"tags"`
+		Other string   `json:"-"`
+	}
+	schema := SchemaOf(args{})
+	if schema.Type != "object" {
+		t.Fatalf("type = %v", schema.Type)
+	}
+	if got, want := schema.Required, []string{"city", "tags"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("required = %v, want %v", got, want)
+	}
+	if got := schema.Properties["city"].Description; got != "城市名" {
+		t.Errorf("description = %v", got)
+	}
+	if got := schema.Properties["unit"].Enum; len(got) != 2 {
+		t.Errorf("enum = %v", got)
+	}
+	if got := schema.Properties["days"].Type; got != "integer" {
+		t.Errorf("days type = %v", got)
+	}
+	if got := schema.Properties["tags"].Items.Type; got != "string" {
+		t.Errorf("tags items = %v", got)
+	}
+	if _, ok := schema.Properties["Other"]; ok {
+		t.Errorf("ignored field in schema")
+	}
+}
+
+func TestDoWithTools(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			// 服务端 goroutine 中不能调用 t.Fatal
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		calls++
+		switch calls {
+		case 1:
+			if len(request.Tools) != 1 || request.Tools[0].Function.Name != "weather" {
+				t.Errorf("tools = %+v", request.Tools)
+			}
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[
+				{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"北京\"}"}}]},
+				"finish_reason":"tool_calls"}]}`))
+		default:
+			last := request.Messages[len(request.Messages)-1]
+			if last.Role != ChatMessageRoleTool || last.ToolCallID != "call_1" || last.Content != "北京: 晴" {
+				t.Errorf("tool message = %+v", last)
+			}
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"北京今天晴。"},"finish_reason":"stop"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	registry := NewToolRegistry()
+	err := RegisterTool(registry, "weather", "查询天气", func(ctx context.Context, args struct {
+		City string `json:"city"`
+	}) (string, error) {
+		return args.City + ": 晴", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := MakeGPT3Client(WithBaseURL(server.URL))
+	resp, appended, err := c.DoWithTools(context.Background(), []ChatCompletionMessage{
+		{Role: ChatMessageRoleUser, Content: "北京天气怎么样？"},
+	}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "北京今天晴。" {
+		t.Errorf("text = %v", resp.Text())
+	}
+	if len(appended) != 3 {
+		t.Errorf("appended = %+v", appended)
+	}
+}
+
+func TestDoWithToolsNilRegistry(t *testing.T) {
+	c := MakeGPT3Client()
+	_, _, err := c.DoWithTools(context.Background(), []ChatCompletionMessage{
+		{Role: ChatMessageRoleUser, Content: "北京天气怎么样？"},
+	}, nil)
+	if err == nil {
+		t.Error("DoWithTools with nil registry succeeded")
+	}
+}
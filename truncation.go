@@ -0,0 +1,260 @@
+package gpt3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sunreaver/go-gpt3/internal/tiktoken"
+)
+
+// TruncationStrategy 历史消息超出 WithMaxsend 的长度时，决定发送哪些消息，通过 WithTruncation 指定。
+type TruncationStrategy interface {
+	// Truncate 返回 token 数(按 CountMessagesTokens 计算)不超过 maxTokens 的消息；say 的最后一条为本轮的消息。
+	// 未超出时应原样返回 say。
+	Truncate(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error)
+}
+
+// TruncationFunc 把函数转换为 TruncationStrategy
+type TruncationFunc func(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error)
+
+func (f TruncationFunc) Truncate(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error) {
+	return f(ctx, say, maxTokens)
+}
+
+// DropOldest 丢弃最早的消息，放不下的那条消息截取末尾的部分内容；默认的策略
+func DropOldest() TruncationStrategy {
+	return TruncationFunc(func(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error) {
+		return dropOldest(say, maxTokens)
+	})
+}
+
+func dropOldest(say []ChatCompletionMessage, maxlen int) ([]ChatCompletionMessage, error) {
+	tmpCount := 0
+CLIP:
+	for i := len(say) - 1; i >= 0; i-- {
+		n := countMessageTokens(say[i])
+		tmpCount += n
+		if tmpCount > maxlen {
+			if i == len(say)-1 {
+				// 第一个就超出
+				return nil, errors.Wrapf(ErrContextLengthExceeded, "输入内容过长; 最长%v, 当前%v", maxlen, tmpCount)
+			}
+			// 这条消息除内容之外的开销
+			overhead := n - countTokens(say[i].Content)
+			if say[i].Role == ChatMessageRoleTool || len(say[i].ToolCalls) > 0 {
+				// 工具调用及其结果不能截断，直接丢弃
+				say = say[i+1:]
+			} else if left := maxlen - (tmpCount - n) - overhead; left > 2 {
+				// 计算可以补多少内容
+				// 截取部分，而不是丢失全部
+				clipped := say[i]
+				clipped.Content = clipTokens(clipped.Content, left)
+				say = append([]ChatCompletionMessage{clipped}, say[i+1:]...)
+			} else {
+				// 补得内容过少，则直接丢弃
+				say = say[i+1:]
+			}
+			break CLIP
+		}
+	}
+	// 丢弃失去对应工具调用的 tool 消息，否则接口会报错
+	for len(say) > 0 && say[0].Role == ChatMessageRoleTool {
+		say = say[1:]
+	}
+	if len(say) == 0 {
+		// 最近的工具调用放不下，其结果也全部丢弃，不能只发送系统提示
+		return nil, errors.Wrapf(ErrContextLengthExceeded, "工具调用及其结果过长; 最长%v", maxlen)
+	}
+	return say, nil
+}
+
+// KeepFirstLast 超出时保留最前面的 first 条消息(如用户最初描述的问题)，以及最后的至多 last 条消息，丢弃中间的消息；
+// last 为0表示在长度允许的范围内保留尽量多的最近消息。最近的消息仍然放不下时按 DropOldest 截断。
+func KeepFirstLast(first, last int) TruncationStrategy {
+	return TruncationFunc(func(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error) {
+		if CountMessagesTokens(say) <= maxTokens {
+			return say, nil
+		}
+		// 本轮的消息总是在后半部分；不修改捕获的 first，策略会被多次及并发调用
+		f := first
+		if f > len(say)-1 {
+			f = len(say) - 1
+		}
+		if f < 0 {
+			f = 0
+		}
+		head, rest := say[:f], say[f:]
+		// 头部末尾的工具调用可能失去结果，丢弃
+		for len(head) > 0 && (len(head[len(head)-1].ToolCalls) > 0 || head[len(head)-1].FunctionCall != nil) {
+			head = head[:len(head)-1]
+		}
+		if last > 0 && len(rest) > last {
+			rest = rest[len(rest)-last:]
+		}
+		left := maxTokens - CountMessagesTokens(head)
+		if left <= 0 {
+			// 头部本身放不下
+			return dropOldest(say, maxTokens)
+		}
+		tail, err := dropOldest(rest, left)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]ChatCompletionMessage(nil), head...), tail...), nil
+	})
+}
+
+// DefaultSummaryPrompt SummarizeOlder 默认的摘要提示
+const DefaultSummaryPrompt = "把以下对话压缩成一段简短的摘要，保留用户最初的问题、已知的关键事实、已经做出的决定以及尚未解决的问题，不要添加对话中没有的内容。"
+
+// summaryPrefix 摘要作为 system 消息发送时的前缀
+const summaryPrefix = "此前对话的摘要：\n"
+
+// maxSummaryCache 缓存的摘要数上限，超出时清空
+const maxSummaryCache = 1024
+
+// SummarizeTruncation 超出时保留最后 KeepLast 条消息，把更早的消息交给模型压缩成摘要，作为一条 system 消息放在最前面。
+// 摘要按被压缩的消息缓存；对话继续增长时，在已缓存的摘要基础上只压缩新增的消息，不必每次重新压缩全部历史。
+// 摘要请求的用量同样计入 ctx 中的 UsageRecorder。SummarizeTruncation 可以并发使用。
+type SummarizeTruncation struct {
+	// KeepLast 原样保留的最近消息数
+	KeepLast int
+	// Prompt 摘要的提示，空表示 DefaultSummaryPrompt
+	Prompt string
+	// Summarize 生成摘要；nil 时 WithTruncation 使用该客户端的模型
+	Summarize func(ctx context.Context, prompt string, msgs []ChatCompletionMessage) (string, error)
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]string
+}
+
+// SummarizeOlder 创建保留最后 keepLast 条消息、压缩更早消息的 SummarizeTruncation
+func SummarizeOlder(keepLast int) *SummarizeTruncation {
+	return &SummarizeTruncation{KeepLast: keepLast}
+}
+
+func (s *SummarizeTruncation) Truncate(ctx context.Context, say []ChatCompletionMessage, maxTokens int) ([]ChatCompletionMessage, error) {
+	if CountMessagesTokens(say) <= maxTokens {
+		return say, nil
+	}
+	keep := s.KeepLast
+	if keep < 1 {
+		keep = 1
+	}
+	split := len(say) - keep
+	// 保留的部分不能以工具调用的结果开头
+	for split > 0 && say[split].Role == ChatMessageRoleTool {
+		split--
+	}
+	if split <= 0 {
+		return dropOldest(say, maxTokens)
+	}
+
+	summary, err := s.summarize(ctx, say[:split])
+	if err != nil {
+		return nil, errors.Wrap(err, "压缩历史消息失败")
+	}
+	note := ChatCompletionMessage{
+		Role:    ChatMessageRoleSystem,
+		Content: summaryPrefix + summary,
+	}
+	left := maxTokens - countMessageTokens(note)
+	if left <= 0 {
+		return dropOldest(say, maxTokens)
+	}
+	tail, err := dropOldest(say[split:], left)
+	if err != nil {
+		return nil, err
+	}
+	return append([]ChatCompletionMessage{note}, tail...), nil
+}
+
+// summarize 返回 older 的摘要；已有 older 某个前缀的摘要时，只压缩该摘要及之后的消息
+func (s *SummarizeTruncation) summarize(ctx context.Context, older []ChatCompletionMessage) (string, error) {
+	if s.Summarize == nil {
+		return "", errors.New("未设置 Summarize")
+	}
+	// keys[i] 为 older[:i+1] 的摘要的缓存 key
+	keys := make([][sha256.Size]byte, len(older))
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for i, msg := range older {
+		if err := enc.Encode(msg); err != nil {
+			return "", err
+		}
+		h.Sum(keys[i][:0])
+	}
+
+	s.mu.Lock()
+	prefix, previous := 0, ""
+	for i := len(older) - 1; i >= 0; i-- {
+		if summary, ok := s.cache[keys[i]]; ok {
+			prefix, previous = i+1, summary
+			break
+		}
+	}
+	s.mu.Unlock()
+	if prefix == len(older) {
+		return previous, nil
+	}
+
+	msgs := older[prefix:]
+	if prefix > 0 {
+		msgs = append([]ChatCompletionMessage{{
+			Role:    ChatMessageRoleSystem,
+			Content: summaryPrefix + previous,
+		}}, msgs...)
+	}
+	prompt := s.Prompt
+	if len(prompt) == 0 {
+		prompt = DefaultSummaryPrompt
+	}
+	summary, err := s.Summarize(ctx, prompt, msgs)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	if s.cache == nil || len(s.cache) >= maxSummaryCache {
+		s.cache = map[[sha256.Size]byte]string{}
+	}
+	s.cache[keys[len(keys)-1]] = summary
+	s.mu.Unlock()
+	return summary, nil
+}
+
+// summarize 使用客户端的模型生成 msgs 的摘要；过长时只压缩末尾的部分
+func (c *GPT3client) summarize(ctx context.Context, prompt string, msgs []ChatCompletionMessage) (string, error) {
+	transcript := strings.Builder{}
+	for _, msg := range msgs {
+		if len(msg.Content) == 0 {
+			continue
+		}
+		transcript.WriteString(msg.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(msg.Content)
+		transcript.WriteString("\n")
+	}
+	system := ChatCompletionMessage{Role: ChatMessageRoleSystem, Content: prompt}
+	maxlen := c.maxsend - tiktoken.TokensReplyPriming - countMessageTokens(system) - countMessageTokens(ChatCompletionMessage{Role: ChatMessageRoleUser})
+	engine := c.defaultEngine
+	if !c.isChatEngine() {
+		engine = Gpt35TurboEngine
+	}
+	resp, err := c.client.ChatCompletion(ctx, ChatCompletionRequest{
+		Model: engine,
+		Messages: []ChatCompletionMessage{system, {
+			Role:    ChatMessageRoleUser,
+			Content: clipTokens(transcript.String(), maxlen),
+		}},
+		MaxTokens: &c.maxtokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text(), nil
+}
@@ -0,0 +1,160 @@
+package gpt3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func truncationHistory(n int) []ChatCompletionMessage {
+	var say []ChatCompletionMessage
+	for i := 0; i < n; i++ {
+		role := ChatMessageRoleUser
+		if i%2 == 1 {
+			role = ChatMessageRoleAssistant
+		}
+		say = append(say, ChatCompletionMessage{Role: role, Content: strings.Repeat(string(rune('a'+i)), 40)})
+	}
+	return say
+}
+
+func TestKeepFirstLast(t *testing.T) {
+	ctx := context.Background()
+	say := truncationHistory(10)
+
+	if got, _ := KeepFirstLast(1, 2).Truncate(ctx, say, CountMessagesTokens(say)); len(got) != 10 {
+		t.Errorf("fits: %v messages", len(got))
+	}
+	got, err := KeepFirstLast(1, 2).Truncate(ctx, say, CountMessagesTokens([]ChatCompletionMessage{say[0], say[8], say[9]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Content != say[0].Content || got[1].Content != say[8].Content || got[2].Content != say[9].Content {
+		t.Errorf("KeepFirstLast(1, 2) = %+v", got)
+	}
+	// last 为0时保留尽量多的最近消息
+	got, err = KeepFirstLast(1, 0).Truncate(ctx, say, CountMessagesTokens([]ChatCompletionMessage{say[0], say[7], say[8], say[9]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[0].Content != say[0].Content || got[1].Content != say[7].Content {
+		t.Errorf("KeepFirstLast(1, 0) = %+v", got)
+	}
+}
+
+func TestKeepFirstLastReuse(t *testing.T) {
+	ctx := context.Background()
+	strategy := KeepFirstLast(3, 1)
+
+	// 短历史不应改变之后调用使用的 first
+	short := truncationHistory(3)
+	if _, err := strategy.Truncate(ctx, short, countMessageTokens(short[0])+countMessageTokens(short[2])); err != nil {
+		t.Fatal(err)
+	}
+	say := truncationHistory(10)
+	got, err := strategy.Truncate(ctx, say, CountMessagesTokens([]ChatCompletionMessage{say[0], say[1], say[2], say[9]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || got[2].Content != say[2].Content || got[3].Content != say[9].Content {
+		t.Errorf("after short history = %+v", got)
+	}
+}
+
+func TestDropOldestToolCallsOnly(t *testing.T) {
+	calls := []ToolCall{
+		{ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "weather", Arguments: `{"city":"北京"}`}},
+		{ID: "call_2", Type: ToolTypeFunction, Function: FunctionCall{Name: "weather", Arguments: `{"city":"上海"}`}},
+	}
+	say := []ChatCompletionMessage{
+		{Role: ChatMessageRoleUser, Content: "北京和上海的天气"},
+		{Role: ChatMessageRoleAssistant, ToolCalls: calls},
+		{Role: ChatMessageRoleTool, ToolCallID: "call_1", Content: "晴"},
+		{Role: ChatMessageRoleTool, ToolCallID: "call_2", Content: "雨"},
+	}
+	// 放得下两条结果，放不下对应的工具调用：结果也要丢弃，不能返回空的历史
+	got, err := DropOldest().Truncate(context.Background(), say, CountMessagesTokens(say[2:])+1)
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("Truncate() = %+v, %v", got, err)
+	}
+}
+
+func TestSummarizeOlder(t *testing.T) {
+	ctx := context.Background()
+	var calls [][]ChatCompletionMessage
+	s := SummarizeOlder(2)
+	s.Summarize = func(ctx context.Context, prompt string, msgs []ChatCompletionMessage) (string, error) {
+		if prompt != DefaultSummaryPrompt {
+			t.Errorf("prompt = %q", prompt)
+		}
+		calls = append(calls, msgs)
+		return "summary", nil
+	}
+
+	say := truncationHistory(8)
+	note := ChatCompletionMessage{Role: ChatMessageRoleSystem, Content: summaryPrefix + "summary"}
+	max := countMessageTokens(note) + CountMessagesTokens(say[6:])
+	got, err := s.Truncate(ctx, say, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Role != ChatMessageRoleSystem || got[0].Content != summaryPrefix+"summary" || got[2].Content != say[7].Content {
+		t.Errorf("Truncate = %+v", got)
+	}
+	if len(calls) != 1 || len(calls[0]) != 6 {
+		t.Fatalf("calls = %v", len(calls))
+	}
+
+	// 相同的历史命中缓存
+	if _, err := s.Truncate(ctx, say, max); err != nil || len(calls) != 1 {
+		t.Errorf("cached: calls = %v, %v", len(calls), err)
+	}
+
+	// 历史增长后只压缩已有摘要及新增的消息
+	say = truncationHistory(10)
+	if _, err := s.Truncate(ctx, say, max); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || len(calls[1]) != 3 || calls[1][0].Content != summaryPrefix+"summary" || calls[1][1].Content != say[6].Content {
+		t.Errorf("incremental call = %+v", calls[len(calls)-1])
+	}
+}
+
+func TestWithTruncationShared(t *testing.T) {
+	newServer := func(requests *int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*requests++
+			io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"summary"}}]}`)
+		}))
+	}
+	var first, second int
+	server1, server2 := newServer(&first), newServer(&second)
+	defer server1.Close()
+	defer server2.Close()
+
+	// 同一个策略传给两个客户端，各自使用自己的模型生成摘要
+	s := SummarizeOlder(2)
+	c1 := MakeGPT3Client(WithBaseURL(server1.URL), WithTruncation(s))
+	c2 := MakeGPT3Client(WithBaseURL(server2.URL), WithTruncation(s))
+	if s.Summarize != nil {
+		t.Error("WithTruncation modified the strategy")
+	}
+	say := truncationHistory(8)
+	max := CountMessagesTokens(say[5:])
+	if _, err := c1.truncation.Truncate(context.Background(), say, max); err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || second != 0 {
+		t.Errorf("client 1: requests = %v, %v", first, second)
+	}
+	if _, err := c2.truncation.Truncate(context.Background(), say, max); err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || second != 1 {
+		t.Errorf("client 2: requests = %v, %v", first, second)
+	}
+}
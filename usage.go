@@ -0,0 +1,154 @@
+package gpt3
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage is the number of tokens a request used.
+type Usage struct {
+	// PromptTokens the number of tokens in the prompt, including cached tokens
+	PromptTokens int `json:"prompt_tokens"`
+	// CompletionTokens the number of tokens in the generated completion
+	CompletionTokens int `json:"completion_tokens"`
+	// TotalTokens the total number of tokens used in the request (prompt + completion)
+	TotalTokens int `json:"total_tokens"`
+	// PromptTokensDetails breakdown of tokens used in the prompt
+	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details"`
+	// CompletionTokensDetails breakdown of tokens used in the completion
+	CompletionTokensDetails CompletionTokensDetails `json:"completion_tokens_details"`
+}
+
+// PromptTokensDetails breakdown of tokens used in the prompt
+type PromptTokensDetails struct {
+	// CachedTokens the number of prompt tokens served from the prompt cache
+	CachedTokens int `json:"cached_tokens"`
+	// AudioTokens the number of audio input tokens
+	AudioTokens int `json:"audio_tokens"`
+}
+
+// CompletionTokensDetails breakdown of tokens used in the completion
+type CompletionTokensDetails struct {
+	// ReasoningTokens the number of tokens generated by the model for reasoning
+	ReasoningTokens int `json:"reasoning_tokens"`
+	// AudioTokens the number of audio output tokens
+	AudioTokens int `json:"audio_tokens"`
+	// AcceptedPredictionTokens the number of tokens in the prediction that appeared in the completion
+	AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
+	// RejectedPredictionTokens the number of tokens in the prediction that did not appear in the completion
+	RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
+}
+
+// Add 返回两个用量之和
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + o.PromptTokens,
+		CompletionTokens: u.CompletionTokens + o.CompletionTokens,
+		TotalTokens:      u.TotalTokens + o.TotalTokens,
+		PromptTokensDetails: PromptTokensDetails{
+			CachedTokens: u.PromptTokensDetails.CachedTokens + o.PromptTokensDetails.CachedTokens,
+			AudioTokens:  u.PromptTokensDetails.AudioTokens + o.PromptTokensDetails.AudioTokens,
+		},
+		CompletionTokensDetails: CompletionTokensDetails{
+			ReasoningTokens:          u.CompletionTokensDetails.ReasoningTokens + o.CompletionTokensDetails.ReasoningTokens,
+			AudioTokens:              u.CompletionTokensDetails.AudioTokens + o.CompletionTokensDetails.AudioTokens,
+			AcceptedPredictionTokens: u.CompletionTokensDetails.AcceptedPredictionTokens + o.CompletionTokensDetails.AcceptedPredictionTokens,
+			RejectedPredictionTokens: u.CompletionTokensDetails.RejectedPredictionTokens + o.CompletionTokensDetails.RejectedPredictionTokens,
+		},
+	}
+}
+
+// IsZero 是否没有任何用量
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// StreamOptions options for streaming responses
+type StreamOptions struct {
+	// IncludeUsage if set, an additional chunk with an empty choices array and the usage of
+	// the entire request is streamed before the data: [DONE] message.
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// UsageRecorder 累计 ctx 内所有请求的 token 用量，可以并发使用
+type UsageRecorder struct {
+	parent *UsageRecorder
+
+	mu       sync.Mutex
+	usage    Usage
+	cost     float64
+	requests int
+}
+
+type usageRecorderKey struct{}
+
+// TrackUsage 返回的 ctx 用于请求时，每个请求(包括流式请求结束时的用量数据块)的用量及花费都会累加到返回的 UsageRecorder，
+// 同时累加到 ctx 中已有的 UsageRecorder。
+//
+//	ctx, usage := gpt3.TrackUsage(ctx)
+//	resp, _, err := client.DoWithTools(ctx, say, registry)
+//	fmt.Println(usage.Usage().PromptTokens, usage.Usage().CompletionTokens)
+func TrackUsage(ctx context.Context) (context.Context, *UsageRecorder) {
+	r := &UsageRecorder{parent: usageRecorderFrom(ctx)}
+	return context.WithValue(ctx, usageRecorderKey{}, r), r
+}
+
+func usageRecorderFrom(ctx context.Context) *UsageRecorder {
+	r, _ := ctx.Value(usageRecorderKey{}).(*UsageRecorder)
+	return r
+}
+
+// Usage 返回目前为止累计的用量
+func (r *UsageRecorder) Usage() Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.usage
+}
+
+// Cost 返回目前为止累计的花费(美元)，按 WithPricing 的价格表计算；价格表中没有的模型不计入
+func (r *UsageRecorder) Cost() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cost
+}
+
+// Requests 返回目前为止报告了用量的请求数
+func (r *UsageRecorder) Requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func (r *UsageRecorder) add(u Usage, cost float64) {
+	for ; r != nil; r = r.parent {
+		r.mu.Lock()
+		r.usage = r.usage.Add(u)
+		r.cost += cost
+		r.requests++
+		r.mu.Unlock()
+	}
+}
+
+// responseUsage 返回响应中的 token 用量
+func responseUsage(result interface{}) Usage {
+	switch r := result.(type) {
+	case CompletionResponseInterface:
+		return r.TokenUsage()
+	case *EditsResponse:
+		if r != nil {
+			return Usage{
+				PromptTokens:     r.Usage.PromptTokens,
+				CompletionTokens: r.Usage.CompletionTokens,
+				TotalTokens:      r.Usage.TotalTokens,
+			}
+		}
+	case *EmbeddingsResponse:
+		if r != nil {
+			return Usage{
+				PromptTokens: r.Usage.PromptTokens,
+				TotalTokens:  r.Usage.TotalTokens,
+			}
+		}
+	}
+	return Usage{}
+}
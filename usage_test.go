@@ -0,0 +1,101 @@
+package gpt3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const usageStreamBody = `data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}
+
+data: {"choices":[],"usage":{"prompt_tokens":20,"completion_tokens":2,"total_tokens":22,"prompt_tokens_details":{"cached_tokens":16}}}
+
+data: [DONE]
+
+`
+
+func TestUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		if req.Stream {
+			if req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+				t.Errorf("stream_options = %+v", req.StreamOptions)
+			}
+			io.WriteString(w, usageStreamBody)
+			return
+		}
+		if bytes.Contains(body, []byte("stream_options")) {
+			t.Errorf("stream_options sent with non-stream request")
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15,"prompt_tokens_details":{"cached_tokens":4}}}`))
+	}))
+	defer server.Close()
+
+	c := MakeGPT3Client(WithBaseURL(server.URL), WithStreamUsage(true))
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+
+	ctx, total := TrackUsage(context.Background())
+	resp, err := c.DoOnce(ctx, say)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := resp.TokenUsage(); u.PromptTokens != 10 || u.CompletionTokens != 5 || u.PromptTokensDetails.CachedTokens != 4 {
+		t.Errorf("DoOnce usage = %+v", u)
+	}
+
+	streamCtx, stream := TrackUsage(ctx)
+	var last Usage
+	err = c.DoStream(streamCtx, say, func(cr CompletionResponseInterface) {
+		if u := cr.TokenUsage(); !u.IsZero() {
+			last = u
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.PromptTokens != 20 || last.PromptTokensDetails.CachedTokens != 16 {
+		t.Errorf("stream usage = %+v", last)
+	}
+	if u := stream.Usage(); u != last || stream.Requests() != 1 {
+		t.Errorf("stream recorder = %+v, %v requests", u, stream.Requests())
+	}
+
+	want := Usage{PromptTokens: 30, CompletionTokens: 7, TotalTokens: 37, PromptTokensDetails: PromptTokensDetails{CachedTokens: 20}}
+	if u := total.Usage(); u != want || total.Requests() != 2 {
+		t.Errorf("total recorder = %+v, %v requests", u, total.Requests())
+	}
+}
+
+func TestStreamUsageDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("stream_options")) {
+			t.Errorf("%v: stream_options sent by default: %s", r.URL.Path, body)
+		}
+		io.WriteString(w, "data: {\"choices\":[{\"index\":0,\"text\":\"ok\",\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer server.Close()
+	say := []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "hi"}}
+
+	for _, engine := range []EngineType{DefaultEngine, "davinci-002"} {
+		c := MakeGPT3Client(WithBaseURL(server.URL), WithDefaultEngine(engine))
+		chunks := 0
+		if err := c.DoStream(context.Background(), say, func(cr CompletionResponseInterface) { chunks++ }); err != nil {
+			t.Fatal(err)
+		}
+		if chunks != 1 {
+			t.Errorf("%v: %v chunks", engine, chunks)
+		}
+		stream, err := c.OpenStream(context.Background(), say)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Close()
+	}
+}